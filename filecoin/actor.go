@@ -0,0 +1,173 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package filecoin
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/ipfs/go-cid"
+)
+
+//ActorType 链上actor类型
+type ActorType string
+
+const (
+	ActorTypeNone           ActorType = "none" //链上还没有此actor，转账会新建账户
+	ActorTypeUnknown        ActorType = "unknown"
+	ActorTypeAccount        ActorType = "account"
+	ActorTypeMultisig       ActorType = "multisig"
+	ActorTypeMiner          ActorType = "storageminer"
+	ActorTypeEVM            ActorType = "evm"
+	ActorTypeEthAccount     ActorType = "ethaccount"
+	ActorTypePlaceholder    ActorType = "placeholder"
+	ActorTypePaymentChannel ActorType = "paymentchannel"
+)
+
+const (
+	//InvokeEVM方法号，转账给EVM合约需用此方法才会执行合约的receive逻辑
+	Message_Method_InvokeEVM = uint64(3844450837)
+
+	//发送给矿工actor的策略
+	MinerSendPolicyWarn   = "warn"
+	MinerSendPolicyReject = "reject"
+)
+
+//ActorCodeRegistry 按网络版本缓存 code CID 到 actor 类型的映射
+type ActorCodeRegistry struct {
+	wm    *WalletManager
+	mu    sync.RWMutex
+	codes map[uint64]map[string]ActorType
+}
+
+//NewActorCodeRegistry 创建actor code注册表
+func NewActorCodeRegistry(wm *WalletManager) *ActorCodeRegistry {
+	return &ActorCodeRegistry{
+		wm:    wm,
+		codes: make(map[uint64]map[string]ActorType),
+	}
+}
+
+//Lookup 根据code CID查找actor类型
+func (reg *ActorCodeRegistry) Lookup(code string) (ActorType, error) {
+	//specs-actors v0-v7 的code是identity CID，内容为 fil/<版本>/<名称>
+	if actorType, ok := actorTypeFromIdentityCid(code); ok {
+		return actorType, nil
+	}
+
+	nv, err := reg.wm.GetNetworkVersion()
+	if err != nil {
+		return ActorTypeUnknown, err
+	}
+
+	reg.mu.RLock()
+	codes, ok := reg.codes[nv]
+	reg.mu.RUnlock()
+
+	if !ok {
+		codes, err = reg.wm.GetActorCodeCIDs(nv)
+		if err != nil {
+			return ActorTypeUnknown, err
+		}
+		reg.mu.Lock()
+		reg.codes[nv] = codes
+		reg.mu.Unlock()
+	}
+
+	actorType, ok := codes[code]
+	if !ok {
+		return ActorTypeUnknown, nil
+	}
+	return actorType, nil
+}
+
+func actorTypeFromIdentityCid(code string) (ActorType, bool) {
+	c, err := cid.Decode(code)
+	if err != nil {
+		return "", false
+	}
+	mh := c.Hash()
+	//identity multihash: 0x00 <长度> <内容>
+	if len(mh) < 2 || mh[0] != 0x00 || int(mh[1]) != len(mh)-2 {
+		return "", false
+	}
+	parts := strings.Split(string(mh[2:]), "/")
+	if len(parts) != 3 || parts[0] != "fil" {
+		return "", false
+	}
+	return ActorType(parts[2]), true
+}
+
+// StateNetworkVersion
+// {"jsonrpc":"2.0","result":18,"id":1}
+func (wm *WalletManager) GetNetworkVersion() (uint64, error) {
+	params := []interface{}{
+		make([]interface{}, 0),
+	}
+	result, err := wm.WalletClient.Call("Filecoin.StateNetworkVersion", params)
+	if err != nil {
+		return 0, err
+	}
+	return result.Uint(), nil
+}
+
+// StateActorCodeCIDs
+// {"jsonrpc":"2.0","result":{"account":{"/":"bafk2bzaceampw4romta75hyz5p4cqriypmpbgnkxncgxgqn6zptv5goutbe2"},"evm":{"/":"bafk2..."}},"id":1}
+func (wm *WalletManager) GetActorCodeCIDs(networkVersion uint64) (map[string]ActorType, error) {
+	params := []interface{}{
+		networkVersion,
+	}
+	result, err := wm.WalletClient.Call("Filecoin.StateActorCodeCIDs", params)
+	if err != nil {
+		return nil, err
+	}
+
+	codes := make(map[string]ActorType)
+	for name, code := range result.Map() {
+		codes[code.Get("/").String()] = ActorType(name)
+	}
+	return codes, nil
+}
+
+//GetActorType 查询地址的actor类型，链上不存在时返回 ActorTypeNone
+func (wm *WalletManager) GetActorType(address string) (ActorType, error) {
+	params := []interface{}{
+		address,
+		make([]interface{}, 0),
+	}
+	result, err := wm.WalletClient.Call("Filecoin.StateGetActor", params)
+	if err != nil {
+		if strings.Contains(err.Error(), "actor not found") {
+			return ActorTypeNone, nil
+		}
+		return ActorTypeUnknown, err
+	}
+
+	return wm.ActorCodes.Lookup(result.Get("Code./").String())
+}
+
+//GetActorTypes 批量查询地址的actor类型
+func (wm *WalletManager) GetActorTypes(addresses ...string) (map[string]ActorType, error) {
+	actorTypes := make(map[string]ActorType, len(addresses))
+	for _, address := range addresses {
+		actorType, err := wm.GetActorType(address)
+		if err != nil {
+			return nil, err
+		}
+		actorTypes[address] = actorType
+	}
+	return actorTypes, nil
+}
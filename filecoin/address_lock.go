@@ -0,0 +1,314 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package filecoin
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/blocktree/openwallet/v2/openwallet"
+)
+
+const (
+	DefaultAddressLockTimeout = 30  //等待地址锁的默认秒数
+	DefaultAddressLockLease   = 600 //构建成功后持有地址锁的默认秒数，超时未广播自动释放
+)
+
+//AddressLocker 发送地址锁，同一地址的 构建→签名→广播 串行执行
+//构建时加锁，构建失败立即释放；构建成功后按消息nonce持有，全部nonce广播后或租约到期释放
+//多个地址按地址排序依次加锁，加锁顺序一致，不会相互等待死锁
+type AddressLocker struct {
+	wm    *WalletManager
+	mu    sync.Mutex
+	seq   uint64
+	locks map[string]*addressLock
+}
+
+type addressLock struct {
+	ch     chan struct{}   //容量为1，写入即加锁
+	refs   int             //持有与等待的构建数，为0时从 locks 中删除
+	lease  uint64          //持有者，0为未持有
+	bound  bool            //已按nonce持有，等待广播
+	nonces map[uint64]bool //等待广播的nonce
+	timer  *time.Timer
+}
+
+//AddressLease 一次构建持有的地址锁
+type AddressLease struct {
+	locker    *AddressLocker
+	id        uint64
+	addresses []string
+
+	//签名包构建：包内交易的地址锁由签名包统一持有，nonce累计到签名包
+	bundle *AddressLease
+	nonces map[string][]uint64
+}
+
+//senderLocker 构建交易时加锁发送地址，AddressLocker 或签名包的 AddressLease
+type senderLocker interface {
+	Lock(timeout time.Duration, addresses ...string) (*AddressLease, error)
+}
+
+//NewAddressLocker 创建地址锁
+func NewAddressLocker(wm *WalletManager) *AddressLocker {
+	return &AddressLocker{
+		wm:    wm,
+		locks: make(map[string]*addressLock),
+	}
+}
+
+//Timeout 等待地址锁的时间
+func (l *AddressLocker) Timeout() time.Duration {
+	if l.wm.Config.AddressLockTimeout == 0 {
+		return DefaultAddressLockTimeout * time.Second
+	}
+	return time.Duration(l.wm.Config.AddressLockTimeout) * time.Second
+}
+
+//Lease 构建成功后持有地址锁的时间
+func (l *AddressLocker) Lease() time.Duration {
+	if l.wm.Config.AddressLockLease == 0 {
+		return DefaultAddressLockLease * time.Second
+	}
+	return time.Duration(l.wm.Config.AddressLockLease) * time.Second
+}
+
+//Lock 按地址排序依次加锁，timeout 内未全部获得时释放已获得的锁并返回错误，timeout 为0时不等待
+func (l *AddressLocker) Lock(timeout time.Duration, addresses ...string) (*AddressLease, error) {
+	lease := l.newLease()
+	if err := l.lock(lease, timeout, addresses); err != nil {
+		return nil, err
+	}
+	return lease, nil
+}
+
+//Bundle 开始一个签名包的构建，同一地址的多笔交易共用地址锁并预留连续的nonce
+//全部构建成功后 Hold 累计的nonce，失败时 Unlock
+func (l *AddressLocker) Bundle() *AddressLease {
+	lease := l.newLease()
+	lease.nonces = make(map[string][]uint64)
+	return lease
+}
+
+func (l *AddressLocker) newLease() *AddressLease {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.seq++
+	return &AddressLease{locker: l, id: l.seq}
+}
+
+//lock 为 lease 依次获得地址锁，失败时释放 lease 持有的全部锁
+func (l *AddressLocker) lock(lease *AddressLease, timeout time.Duration, addresses []string) error {
+	addresses = sortedAddresses(addresses)
+
+	var deadline <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		deadline = timer.C
+	}
+
+	for _, address := range addresses {
+		lock := l.get(address)
+		if !acquire(lock.ch, deadline) {
+			l.mu.Lock()
+			l.unref(address, lock)
+			l.mu.Unlock()
+			lease.Unlock()
+			return openwallet.Errorf(openwallet.ErrCreateRawTransactionFailed, "address %s is busy with another transaction, wait timeout", address)
+		}
+		l.mu.Lock()
+		lock.lease = lease.id
+		lock.bound = false
+		l.mu.Unlock()
+		lease.addresses = append(lease.addresses, address)
+	}
+	return nil
+}
+
+//Lock 签名包内一笔交易加锁，签名包尚未持有的地址由签名包加锁；返回的 lease 不单独持有或释放地址锁
+func (lease *AddressLease) Lock(timeout time.Duration, addresses ...string) (*AddressLease, error) {
+	held := make(map[string]bool, len(lease.addresses))
+	for _, address := range lease.addresses {
+		held[address] = true
+	}
+	missing := make([]string, 0, len(addresses))
+	for _, address := range addresses {
+		if !held[address] {
+			missing = append(missing, address)
+		}
+	}
+	if err := lease.locker.lock(lease, timeout, missing); err != nil {
+		return nil, err
+	}
+	return &AddressLease{locker: lease.locker, bundle: lease}, nil
+}
+
+//Nonces 签名包累计的nonce
+func (lease *AddressLease) Nonces() map[string][]uint64 {
+	return lease.nonces
+}
+
+//isBundle 是否为签名包的 lease
+func (lease *AddressLease) isBundle() bool {
+	return lease.nonces != nil
+}
+
+//Unlock 广播后移除按 (地址, nonce) 持有的nonce，全部nonce广播后释放锁；锁已过期或被其他构建持有时不做处理
+func (l *AddressLocker) Unlock(address string, nonce uint64) {
+	l.mu.Lock()
+	lock, ok := l.locks[address]
+	if !ok || lock.lease == 0 || !lock.bound || !lock.nonces[nonce] {
+		l.mu.Unlock()
+		return
+	}
+	delete(lock.nonces, nonce)
+	if len(lock.nonces) > 0 {
+		l.mu.Unlock()
+		return
+	}
+	id := lock.lease
+	l.mu.Unlock()
+	l.release(address, id)
+}
+
+//Unlock 释放本次持有的全部地址锁，签名包内的交易由签名包统一释放
+func (lease *AddressLease) Unlock() {
+	if lease.bundle != nil {
+		return
+	}
+	for _, address := range lease.addresses {
+		lease.locker.release(address, lease.id)
+	}
+}
+
+//Hold 构建成功，地址锁继续持有到该地址全部nonce的消息广播或租约到期；nonces 中没有的地址立即释放
+//签名包内的交易只把nonce累计到签名包；签名包的地址锁没有租约，持有到 SubmitSignBundle 或 CancelSignBundle
+func (lease *AddressLease) Hold(nonces map[string][]uint64) {
+	if lease.bundle != nil {
+		for address, list := range nonces {
+			lease.bundle.nonces[address] = append(lease.bundle.nonces[address], list...)
+		}
+		return
+	}
+	l := lease.locker
+	duration := l.Lease()
+	for _, address := range lease.addresses {
+		list := nonces[address]
+		if len(list) == 0 {
+			l.release(address, lease.id)
+			continue
+		}
+		address, id := address, lease.id
+		l.mu.Lock()
+		lock := l.locks[address]
+		if lock.lease == id {
+			lock.bound = true
+			lock.nonces = make(map[uint64]bool, len(list))
+			for _, nonce := range list {
+				lock.nonces[nonce] = true
+			}
+			if !lease.isBundle() {
+				lock.timer = time.AfterFunc(duration, func() {
+					l.wm.Log.Warningf("address %s lock for nonces %v expired before submit", address, list)
+					l.release(address, id)
+				})
+			}
+		}
+		l.mu.Unlock()
+	}
+}
+
+//singleNonces 每个地址只持有一个nonce时的 Hold 参数
+func singleNonces(nonces map[string]uint64) map[string][]uint64 {
+	held := make(map[string][]uint64, len(nonces))
+	for address, nonce := range nonces {
+		held[address] = []uint64{nonce}
+	}
+	return held
+}
+
+//get 取得地址的锁并计入等待，之后须 acquire 成功后 release，或失败后 unref
+func (l *AddressLocker) get(address string) *addressLock {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	lock, ok := l.locks[address]
+	if !ok {
+		lock = &addressLock{ch: make(chan struct{}, 1)}
+		l.locks[address] = lock
+	}
+	lock.refs++
+	return lock
+}
+
+//unref 不再持有或等待地址锁，没有其他持有者与等待者时删除，避免 locks 随地址数增长，调用方持有 l.mu
+func (l *AddressLocker) unref(address string, lock *addressLock) {
+	lock.refs--
+	if lock.refs == 0 {
+		delete(l.locks, address)
+	}
+}
+
+func (l *AddressLocker) release(address string, id uint64) {
+	l.mu.Lock()
+	lock, ok := l.locks[address]
+	if !ok || lock.lease != id {
+		l.mu.Unlock()
+		return
+	}
+	if lock.timer != nil {
+		lock.timer.Stop()
+		lock.timer = nil
+	}
+	lock.lease = 0
+	lock.bound = false
+	lock.nonces = nil
+	l.unref(address, lock)
+	l.mu.Unlock()
+	<-lock.ch
+}
+
+func acquire(ch chan struct{}, deadline <-chan time.Time) bool {
+	if deadline == nil {
+		select {
+		case ch <- struct{}{}:
+			return true
+		default:
+			return false
+		}
+	}
+	select {
+	case ch <- struct{}{}:
+		return true
+	case <-deadline:
+		return false
+	}
+}
+
+//sortedAddresses 去重并排序，保证多地址加锁顺序一致
+func sortedAddresses(addresses []string) []string {
+	seen := make(map[string]bool, len(addresses))
+	sorted := make([]string, 0, len(addresses))
+	for _, address := range addresses {
+		if !seen[address] {
+			seen[address] = true
+			sorted = append(sorted, address)
+		}
+	}
+	sort.Strings(sorted)
+	return sorted
+}
@@ -0,0 +1,146 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package filecoin
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func lockCount(l *AddressLocker) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return len(l.locks)
+}
+
+func TestAddressLocker_OverlappingAddresses(t *testing.T) {
+	l := NewWalletManager().AddressLocker
+
+	//两个构建以相反顺序请求相同的地址，按排序加锁不会相互等待
+	var wg sync.WaitGroup
+	for _, addresses := range [][]string{{"f1a", "f1b"}, {"f1b", "f1a"}} {
+		wg.Add(1)
+		go func(addresses []string) {
+			defer wg.Done()
+			for i := 0; i < 200; i++ {
+				lease, err := l.Lock(5*time.Second, addresses...)
+				if err != nil {
+					t.Errorf("Lock %v failed: %v", addresses, err)
+					return
+				}
+				lease.Unlock()
+			}
+		}(addresses)
+	}
+	wg.Wait()
+
+	if n := lockCount(l); n != 0 {
+		t.Errorf("%d address locks left after all leases ended", n)
+	}
+}
+
+func TestAddressLocker_Timeout(t *testing.T) {
+	l := NewWalletManager().AddressLocker
+
+	lease, err := l.Lock(0, "f1a")
+	if err != nil {
+		t.Fatalf("Lock failed: %v", err)
+	}
+
+	//timeout 为0时不等待
+	start := time.Now()
+	if _, err := l.Lock(0, "f1a"); err == nil {
+		t.Fatal("Lock of a busy address with timeout 0 should fail")
+	}
+	if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+		t.Errorf("Lock with timeout 0 waited %v", elapsed)
+	}
+
+	start = time.Now()
+	if _, err := l.Lock(50*time.Millisecond, "f1a"); err == nil {
+		t.Fatal("Lock of a busy address should time out")
+	}
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Errorf("Lock returned after %v, before the timeout", elapsed)
+	}
+
+	//获得部分地址后超时，已获得的地址锁须释放
+	if _, err := l.Lock(0, "f1b", "f1a"); err == nil {
+		t.Fatal("Lock including a busy address should fail")
+	}
+	other, err := l.Lock(0, "f1b")
+	if err != nil {
+		t.Fatalf("f1b is still locked after a failed Lock: %v", err)
+	}
+	other.Unlock()
+
+	//等待中的构建在地址释放后获得锁
+	done := make(chan error, 1)
+	go func() {
+		waiter, err := l.Lock(time.Second, "f1a")
+		if err == nil {
+			waiter.Unlock()
+		}
+		done <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+	lease.Unlock()
+	if err := <-done; err != nil {
+		t.Errorf("waiting Lock failed after release: %v", err)
+	}
+
+	if n := lockCount(l); n != 0 {
+		t.Errorf("%d address locks left after all leases ended", n)
+	}
+}
+
+func TestAddressLocker_Hold(t *testing.T) {
+	wm := NewWalletManager()
+	wm.Config.AddressLockLease = 1
+	l := wm.AddressLocker
+
+	lease, err := l.Lock(0, "f1a", "f1b")
+	if err != nil {
+		t.Fatalf("Lock failed: %v", err)
+	}
+	//f1b 没有消息，立即释放；f1a 持有到两个nonce都广播
+	lease.Hold(map[string][]uint64{"f1a": {7, 8}})
+	if other, err := l.Lock(0, "f1b"); err != nil {
+		t.Errorf("f1b should be released by Hold: %v", err)
+	} else {
+		other.Unlock()
+	}
+
+	l.Unlock("f1a", 7)
+	if _, err := l.Lock(0, "f1a"); err == nil {
+		t.Fatal("f1a should be held until nonce 8 is submitted")
+	}
+	l.Unlock("f1a", 8)
+	other, err := l.Lock(0, "f1a")
+	if err != nil {
+		t.Fatalf("f1a should be released after all nonces are submitted: %v", err)
+	}
+
+	//未广播时租约到期自动释放
+	other.Hold(map[string][]uint64{"f1a": {9}})
+	if _, err := l.Lock(0, "f1a"); err == nil {
+		t.Fatal("f1a should be held before the lease expires")
+	}
+	if _, err := l.Lock(2*time.Second, "f1a"); err != nil {
+		t.Fatalf("f1a should be released when the lease expires: %v", err)
+	}
+}
@@ -0,0 +1,323 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package filecoin
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/asdine/storm"
+)
+
+const (
+	addressDBFile = "address.db" //地址映射数据库文件
+)
+
+//ErrNotAccountActor ID地址对应多签、矿工等非账户actor，没有robust地址
+var ErrNotAccountActor = errors.New("not an account actor")
+
+//AddressMapping ID地址(f0...)与robust地址(f1/f3...)的映射记录
+type AddressMapping struct {
+	ID     string `storm:"id"`
+	Robust string `storm:"unique"`
+}
+
+//NonAccountActor 非账户actor的ID地址记录，避免每条消息都查询 StateAccountKey
+type NonAccountActor struct {
+	ID   string `storm:"id"`
+	Type ActorType
+}
+
+//AddressResolver ID地址与robust地址互相解析，带本地持久化的双向缓存
+type AddressResolver struct {
+	wm         *WalletManager
+	mu         sync.RWMutex
+	dbMu       sync.Mutex
+	db         *storm.DB
+	idToRobust map[string]string
+	robustToID map[string]string
+	nonAccount map[string]ActorType
+}
+
+//NewAddressResolver 创建地址解析器
+func NewAddressResolver(wm *WalletManager) *AddressResolver {
+	return &AddressResolver{
+		wm:         wm,
+		idToRobust: make(map[string]string),
+		robustToID: make(map[string]string),
+		nonAccount: make(map[string]ActorType),
+	}
+}
+
+//IsIDAddress 是否ID地址，格式：f0... / t0...
+func IsIDAddress(address string) bool {
+	return len(address) > 2 && (strings.HasPrefix(address, "f0") || strings.HasPrefix(address, "t0"))
+}
+
+//ToRobust 把ID地址解析为robust地址，非ID地址原样返回；非账户actor返回 ErrNotAccountActor，其余错误为节点查询失败
+func (r *AddressResolver) ToRobust(address string) (string, error) {
+	if !IsIDAddress(address) {
+		return address, nil
+	}
+
+	r.mu.RLock()
+	robust, ok := r.idToRobust[address]
+	_, nonAccount := r.nonAccount[address]
+	r.mu.RUnlock()
+	if ok {
+		return robust, nil
+	}
+	if nonAccount {
+		return "", ErrNotAccountActor
+	}
+
+	mapping, err := r.loadMapping(address)
+	if err == nil {
+		r.remember(mapping.ID, mapping.Robust)
+		return mapping.Robust, nil
+	}
+	if actor, err := r.loadNonAccount(address); err == nil {
+		r.rememberNonAccount(actor.ID, actor.Type)
+		return "", ErrNotAccountActor
+	}
+
+	robust, err = r.wm.StateAccountKey(address)
+	if err != nil {
+		//区分非账户actor与节点查询失败，查询失败时由调用方重试
+		actorType, typeErr := r.wm.GetActorType(address)
+		if typeErr != nil {
+			return "", err
+		}
+		if isNonAccountActor(actorType) {
+			r.rememberNonAccount(address, actorType)
+			r.saveNonAccount(address, actorType)
+			return "", ErrNotAccountActor
+		}
+		return "", err
+	}
+
+	r.remember(address, robust)
+	r.saveMapping(address, robust)
+	return robust, nil
+}
+
+//isNonAccountActor 确定没有robust地址的actor类型；占位actor之后可能成为账户，不计入
+func isNonAccountActor(actorType ActorType) bool {
+	switch actorType {
+	case ActorTypeAccount, ActorTypeEthAccount, ActorTypePlaceholder, ActorTypeNone, ActorTypeUnknown:
+		return false
+	}
+	return true
+}
+
+//ToID 把robust地址解析为ID地址，ID地址原样返回
+func (r *AddressResolver) ToID(address string) (string, error) {
+	if IsIDAddress(address) {
+		return address, nil
+	}
+
+	r.mu.RLock()
+	id, ok := r.robustToID[address]
+	r.mu.RUnlock()
+	if ok {
+		return id, nil
+	}
+
+	mapping, err := r.loadMappingByRobust(address)
+	if err == nil {
+		r.remember(mapping.ID, mapping.Robust)
+		return mapping.ID, nil
+	}
+
+	id, err = r.wm.StateLookupID(address)
+	if err != nil {
+		return "", err
+	}
+
+	r.remember(id, address)
+	r.saveMapping(id, address)
+	return id, nil
+}
+
+//Normalize 返回地址的robust形式与ID形式；robust地址的ID只查缓存，未知时为空
+//非账户actor的ID地址原样返回，节点查询失败时返回错误
+func (r *AddressResolver) Normalize(address string) (robust string, id string, err error) {
+	if IsIDAddress(address) {
+		robust, err := r.ToRobust(address)
+		if err == ErrNotAccountActor {
+			return address, address, nil
+		}
+		if err != nil {
+			return "", "", fmt.Errorf("resolve robust address of %s failed: %v", address, err)
+		}
+		return robust, address, nil
+	}
+
+	r.mu.RLock()
+	id = r.robustToID[address]
+	r.mu.RUnlock()
+	return address, id, nil
+}
+
+func (r *AddressResolver) remember(id, robust string) {
+	r.mu.Lock()
+	r.idToRobust[id] = robust
+	r.robustToID[robust] = id
+	r.mu.Unlock()
+}
+
+func (r *AddressResolver) rememberNonAccount(id string, actorType ActorType) {
+	r.mu.Lock()
+	r.nonAccount[id] = actorType
+	r.mu.Unlock()
+}
+
+//openDB 打开地址映射数据库，扫块是多线程的，所以只打开一次并复用
+func (r *AddressResolver) openDB() (*storm.DB, error) {
+	r.dbMu.Lock()
+	defer r.dbMu.Unlock()
+
+	if r.db != nil {
+		return r.db, nil
+	}
+	if len(r.wm.Config.DBPath) == 0 {
+		return nil, fmt.Errorf("address db path is not setup")
+	}
+	db, err := storm.Open(filepath.Join(r.wm.Config.DBPath, addressDBFile))
+	if err != nil {
+		return nil, err
+	}
+	r.db = db
+	return r.db, nil
+}
+
+//Close 关闭地址映射数据库，之后再访问时重新打开
+func (r *AddressResolver) Close() error {
+	r.dbMu.Lock()
+	defer r.dbMu.Unlock()
+
+	if r.db == nil {
+		return nil
+	}
+	err := r.db.Close()
+	r.db = nil
+	return err
+}
+
+func (r *AddressResolver) loadMapping(id string) (*AddressMapping, error) {
+	db, err := r.openDB()
+	if err != nil {
+		return nil, err
+	}
+
+	var mapping AddressMapping
+	err = db.One("ID", id, &mapping)
+	if err != nil {
+		return nil, err
+	}
+	return &mapping, nil
+}
+
+func (r *AddressResolver) loadMappingByRobust(robust string) (*AddressMapping, error) {
+	db, err := r.openDB()
+	if err != nil {
+		return nil, err
+	}
+
+	var mapping AddressMapping
+	err = db.One("Robust", robust, &mapping)
+	if err != nil {
+		return nil, err
+	}
+	return &mapping, nil
+}
+
+func (r *AddressResolver) saveMapping(id, robust string) {
+	db, err := r.openDB()
+	if err != nil {
+		r.wm.Log.Std.Error("open address db failed, err=%v", err)
+		return
+	}
+
+	err = db.Save(&AddressMapping{ID: id, Robust: robust})
+	if err != nil {
+		r.wm.Log.Std.Error("save address mapping %s <-> %s failed, err=%v", id, robust, err)
+	}
+}
+
+func (r *AddressResolver) loadNonAccount(id string) (*NonAccountActor, error) {
+	db, err := r.openDB()
+	if err != nil {
+		return nil, err
+	}
+
+	var actor NonAccountActor
+	err = db.One("ID", id, &actor)
+	if err != nil {
+		return nil, err
+	}
+	return &actor, nil
+}
+
+func (r *AddressResolver) saveNonAccount(id string, actorType ActorType) {
+	db, err := r.openDB()
+	if err != nil {
+		r.wm.Log.Std.Error("open address db failed, err=%v", err)
+		return
+	}
+
+	err = db.Save(&NonAccountActor{ID: id, Type: actorType})
+	if err != nil {
+		r.wm.Log.Std.Error("save non-account actor %s failed, err=%v", id, err)
+	}
+}
+
+// StateAccountKey
+// {"jsonrpc":"2.0","result":"f1abjxfbp274xpdqcpuaykwkfb43omjotacm2p3za","id":1}
+func (wm *WalletManager) StateAccountKey(address string) (string, error) {
+	params := []interface{}{
+		address,
+		make([]interface{}, 0),
+	}
+	result, err := wm.WalletClient.Call("Filecoin.StateAccountKey", params)
+	if err != nil {
+		return "", err
+	}
+	if len(result.Str) == 0 {
+		return "", fmt.Errorf("address %s has no account key", address)
+	}
+	return result.Str, nil
+}
+
+// StateLookupID
+// {"jsonrpc":"2.0","result":"f01234","id":1}
+func (wm *WalletManager) StateLookupID(address string) (string, error) {
+	params := []interface{}{
+		address,
+		make([]interface{}, 0),
+	}
+	result, err := wm.WalletClient.Call("Filecoin.StateLookupID", params)
+	if err != nil {
+		return "", err
+	}
+	if len(result.Str) == 0 {
+		return "", fmt.Errorf("address %s has no id", address)
+	}
+	return result.Str, nil
+}
@@ -0,0 +1,314 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package filecoin
+
+import (
+	"fmt"
+	"math/big"
+	"sort"
+	"sync"
+
+	"github.com/tidwall/gjson"
+)
+
+const (
+	DefaultBaseFeeHistorySize = 60      //默认保留的tipset数，约30分钟
+	MaxBaseFeeBackfill        = 5       //每次更新最多向前补齐的tipset数，其余由区块扫描器记录或下次更新补齐
+	DefaultFeePercentile      = 50      //GetRawTransactionFeeRate 使用的百分位
+	BaseFeeCapPercent         = 200     //fee cap 中 base fee 的余量，可承受约6个连续满块的上涨（1.125^6）
+	StandardSendGasLimit      = 1000000 //未配置 fixGasLimit 时标准转账的gas limit
+
+	MinGasPremium = 100000 //与lotus的最低premium一致
+)
+
+//BaseFeeSample 一个tipset的 ParentBaseFee，以及按该base fee执行的父tipset消息的premium
+type BaseFeeSample struct {
+	Height   uint64
+	BaseFee  *big.Int
+	Premiums []*big.Int
+}
+
+//BaseFeeHistory 最近若干tipset的base fee，按高度升序保存
+type BaseFeeHistory struct {
+	mu      sync.RWMutex
+	size    int
+	samples []*BaseFeeSample
+}
+
+func NewBaseFeeHistory(size int) *BaseFeeHistory {
+	if size <= 0 {
+		size = DefaultBaseFeeHistorySize
+	}
+	return &BaseFeeHistory{size: size}
+}
+
+//Size 保留的tipset数
+func (h *BaseFeeHistory) Size() int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.size
+}
+
+//Add 添加或更新一个高度的记录，超出保留数量时丢弃最旧的记录
+func (h *BaseFeeHistory) Add(sample *BaseFeeSample) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	i := sort.Search(len(h.samples), func(i int) bool {
+		return h.samples[i].Height >= sample.Height
+	})
+	if i < len(h.samples) && h.samples[i].Height == sample.Height {
+		if len(sample.Premiums) == 0 {
+			sample.Premiums = h.samples[i].Premiums
+		}
+		h.samples[i] = sample
+		return
+	}
+	h.samples = append(h.samples, nil)
+	copy(h.samples[i+1:], h.samples[i:])
+	h.samples[i] = sample
+	if len(h.samples) > h.size {
+		h.samples = h.samples[len(h.samples)-h.size:]
+	}
+}
+
+//Has 是否已有该高度的记录
+func (h *BaseFeeHistory) Has(height uint64) bool {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	i := sort.Search(len(h.samples), func(i int) bool {
+		return h.samples[i].Height >= height
+	})
+	return i < len(h.samples) && h.samples[i].Height == height
+}
+
+//Samples 按高度升序返回记录的副本
+func (h *BaseFeeHistory) Samples() []*BaseFeeSample {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	samples := make([]*BaseFeeSample, len(h.samples))
+	copy(samples, h.samples)
+	return samples
+}
+
+//Latest 最新的记录，没有记录时返回nil
+func (h *BaseFeeHistory) Latest() *BaseFeeSample {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	if len(h.samples) == 0 {
+		return nil
+	}
+	return h.samples[len(h.samples)-1]
+}
+
+//BaseFeePercentile base fee 的百分位值，没有记录时返回nil
+func (h *BaseFeeHistory) BaseFeePercentile(percentile int) *big.Int {
+	h.mu.RLock()
+	values := make([]*big.Int, 0, len(h.samples))
+	for _, s := range h.samples {
+		values = append(values, s.BaseFee)
+	}
+	h.mu.RUnlock()
+	return percentileOf(values, percentile)
+}
+
+//PremiumPercentile 已上链消息premium的百分位值，没有记录时返回nil
+func (h *BaseFeeHistory) PremiumPercentile(percentile int) *big.Int {
+	h.mu.RLock()
+	values := make([]*big.Int, 0)
+	for _, s := range h.samples {
+		values = append(values, s.Premiums...)
+	}
+	h.mu.RUnlock()
+	return percentileOf(values, percentile)
+}
+
+//percentileOf 最近秩法计算百分位，percentile 取值 1-100
+func percentileOf(values []*big.Int, percentile int) *big.Int {
+	if len(values) == 0 {
+		return nil
+	}
+	if percentile < 1 {
+		percentile = 1
+	}
+	if percentile > 100 {
+		percentile = 100
+	}
+	sorted := make([]*big.Int, len(values))
+	copy(sorted, values)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Cmp(sorted[j]) < 0
+	})
+	rank := (percentile*len(sorted) + 99) / 100
+	return new(big.Int).Set(sorted[rank-1])
+}
+
+//FeeRateEstimate 按近期base fee与premium估算的每gas费率与标准转账的手续费
+type FeeRateEstimate struct {
+	Percentile int
+	BaseFee    *big.Int //base fee 的百分位值，不低于最新的base fee
+	GasPremium *big.Int //已上链消息premium的百分位值
+	GasFeeCap  *big.Int //base fee * BaseFeeCapPercent% + premium
+	FeeRate    *big.Int //预计每gas的实际费用：base fee + premium
+	GasLimit   *big.Int //标准转账的gas limit
+	Fee        *big.Int //预计实际手续费：gasLimit * FeeRate
+	MaxFee     *big.Int //手续费上限：gasLimit * GasFeeCap
+}
+
+//getTipSetBaseFee 获取tipset的 ParentBaseFee，同一tipset中所有区块的值相同
+func getTipSetBaseFee(tipSet *TipSet) (*big.Int, error) {
+	if len(tipSet.Blks) == 0 {
+		return nil, fmt.Errorf("tipset %d has no blocks", tipSet.Height)
+	}
+	baseFee, ok := new(big.Int).SetString(tipSet.Blks[0].ParentBaseFee, 10)
+	if !ok {
+		return nil, fmt.Errorf("invalid ParentBaseFee %q at height %d", tipSet.Blks[0].ParentBaseFee, tipSet.Height)
+	}
+	return baseFee, nil
+}
+
+//getParentMessagePremiums 以该tipset的base fee执行的父tipset消息的premium
+func (wm *WalletManager) getParentMessagePremiums(tipSet *TipSet) ([]*big.Int, error) {
+	if len(tipSet.Blks) == 0 {
+		return nil, nil
+	}
+	params := []interface{}{
+		map[string]interface{}{
+			"/": tipSet.Blks[0].BlockHeaderCid,
+		},
+	}
+	result, err := wm.WalletClient.Call("Filecoin.ChainGetParentMessages", params)
+	if err != nil {
+		return nil, err
+	}
+	premiums := make([]*big.Int, 0)
+	for _, message := range result.Array() {
+		premium, ok := new(big.Int).SetString(gjson.Get(message.Raw, "Message.GasPremium").String(), 10)
+		if ok {
+			premiums = append(premiums, premium)
+		}
+	}
+	return premiums, nil
+}
+
+//RecordBaseFee 记录已获取的tipset的base fee，区块扫描器扫描时调用，免去 UpdateBaseFeeHistory 再次查询
+func (wm *WalletManager) RecordBaseFee(tipSet *TipSet) {
+	if tipSet == nil || wm.BaseFeeHistory.Has(tipSet.Height) {
+		return
+	}
+	baseFee, err := getTipSetBaseFee(tipSet)
+	if err != nil {
+		wm.Log.Warningf("record base fee failed: %v", err)
+		return
+	}
+	wm.BaseFeeHistory.Add(&BaseFeeSample{Height: tipSet.Height, BaseFee: baseFee})
+}
+
+//UpdateBaseFeeHistory 从链头向前补齐缺少的tipset，每次最多查询 MaxBaseFeeBackfill 个，链头的父消息premium一并记录
+func (wm *WalletManager) UpdateBaseFeeHistory() error {
+	head, err := wm.GetMaxTipsetHeight()
+	if err != nil {
+		return err
+	}
+
+	size := wm.BaseFeeHistory.Size()
+	var lowest uint64
+	if head+1 > uint64(size) {
+		lowest = head + 1 - uint64(size)
+	}
+
+	//历史从链头向前连续记录，已覆盖到窗口底部时遇到已记录的高度即可停止；否则跳过已记录的高度继续补齐
+	covered := false
+	if samples := wm.BaseFeeHistory.Samples(); len(samples) > 0 && samples[0].Height <= lowest {
+		covered = true
+	}
+
+	fetched := 0
+	for height := head; height >= lowest; height-- {
+		if wm.BaseFeeHistory.Has(height) {
+			if covered || height == 0 {
+				break
+			}
+			continue
+		}
+		if fetched >= MaxBaseFeeBackfill {
+			break
+		}
+		fetched++
+		tipSet, err := wm.GetTipSetByHeight(height)
+		if err != nil {
+			return err
+		}
+		baseFee, err := getTipSetBaseFee(tipSet)
+		if err != nil {
+			return err
+		}
+		sample := &BaseFeeSample{Height: tipSet.Height, BaseFee: baseFee}
+		if height == head {
+			sample.Premiums, err = wm.getParentMessagePremiums(tipSet)
+			if err != nil {
+				wm.Log.Warningf("get parent message premiums at %d failed: %v", tipSet.Height, err)
+			}
+		}
+		wm.BaseFeeHistory.Add(sample)
+
+		//空轮次返回之前的tipset，直接跳到该高度
+		if tipSet.Height < height {
+			height = tipSet.Height
+		}
+		if height == 0 {
+			break
+		}
+	}
+	return nil
+}
+
+//GetFeeRateEstimate 更新base fee历史，按百分位估算每gas费率与标准转账的手续费
+func (wm *WalletManager) GetFeeRateEstimate(percentile int) (*FeeRateEstimate, error) {
+	if err := wm.UpdateBaseFeeHistory(); err != nil {
+		return nil, err
+	}
+	latest := wm.BaseFeeHistory.Latest()
+	if latest == nil {
+		return nil, fmt.Errorf("base fee history is empty")
+	}
+
+	baseFee := maxBigInt(latest.BaseFee, wm.BaseFeeHistory.BaseFeePercentile(percentile))
+	premium := wm.BaseFeeHistory.PremiumPercentile(percentile)
+	if premium == nil || premium.Cmp(big.NewInt(MinGasPremium)) < 0 {
+		premium = big.NewInt(MinGasPremium)
+	}
+
+	gasFeeCap := percentOf(baseFee, BaseFeeCapPercent)
+	gasFeeCap.Add(gasFeeCap, premium)
+
+	gasLimit := big.NewInt(StandardSendGasLimit)
+	if wm.Config.FixGasLimit != nil && wm.Config.FixGasLimit.Sign() > 0 {
+		gasLimit = new(big.Int).Set(wm.Config.FixGasLimit)
+	}
+
+	feeRate := new(big.Int).Add(baseFee, premium)
+	return &FeeRateEstimate{
+		Percentile: percentile,
+		BaseFee:    baseFee,
+		GasPremium: premium,
+		GasFeeCap:  gasFeeCap,
+		FeeRate:    feeRate,
+		GasLimit:   gasLimit,
+		Fee:        new(big.Int).Mul(gasLimit, feeRate),
+		MaxFee:     new(big.Int).Mul(gasLimit, gasFeeCap),
+	}, nil
+}
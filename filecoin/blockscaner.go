@@ -16,10 +16,12 @@
 package filecoin
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/asdine/storm"
-	"github.com/blocktree/openwallet/v2/common"
+	"github.com/blocktree/filecoin-adapter/filecoinTransaction"
+	"math/big"
 	"strings"
 
 	"github.com/blocktree/openwallet/v2/openwallet"
@@ -248,6 +250,9 @@ func (bs *FILBlockScanner) ScanBlockTask() {
 	//重扫失败区块
 	bs.RescanFailedRecord()
 
+	//更新已广播消息的上链结果，异步执行
+	bs.wm.MessageTracker.PollAsync(currentHeight)
+
 }
 
 //ScanBlock 扫描指定高度区块
@@ -276,6 +281,7 @@ func (bs *FILBlockScanner) scanBlock(height uint64) (*OwBlock, error) {
 	}
 
 	bs.wm.Log.Std.Info("block scanner scanning height: %d ...", block.Height)
+	bs.wm.RecordBaseFee(block.TipSet)
 
 	err = bs.BatchExtractTransaction(block.Height, block.Hash, block.Transactions, false)
 	if err != nil {
@@ -519,6 +525,13 @@ func (bs *FILBlockScanner) extractTransaction(trx *Transaction, result *ExtractR
 		return
 	}
 
+	//ID地址(f0...)转为robust地址后再匹配，两种形式都保留在交易单中；解析失败时整个区块重扫，避免漏掉充值
+	if err := bs.normalizeTransactionAddress(trx); err != nil {
+		bs.wm.Log.Std.Error("transaction %s normalize address failed, err=%v", trx.Hash, err)
+		result.Success = false
+		return
+	}
+
 	from := trx.From
 	to := trx.To
 
@@ -568,6 +581,24 @@ func (bs *FILBlockScanner) extractTransaction(trx *Transaction, result *ExtractR
 	}
 }
 
+//normalizeTransactionAddress 把交易单的From/To统一为robust地址，并记录ID地址
+func (bs *FILBlockScanner) normalizeTransactionAddress(trx *Transaction) error {
+	if bs.wm.AddressResolver == nil {
+		return nil
+	}
+	robustFrom, fromID, err := bs.wm.AddressResolver.Normalize(trx.From)
+	if err != nil {
+		return err
+	}
+	robustTo, toID, err := bs.wm.AddressResolver.Normalize(trx.To)
+	if err != nil {
+		return err
+	}
+	trx.From, trx.FromID = robustFrom, fromID
+	trx.To, trx.ToID = robustTo, toID
+	return nil
+}
+
 //InitExtractResult optType = 0: 输入输出提取，1: 输入提取，2：输出提取
 func (bs *FILBlockScanner) InitExtractResult(sourceKey string, tx *Transaction, result *ExtractResult, optType int64) {
 
@@ -580,22 +611,27 @@ func (bs *FILBlockScanner) InitExtractResult(sourceKey string, tx *Transaction,
 
 	//bs.wm.Log.Std.Info("find_extract_transaction, hash : %v, to: %v, status: %v", tx.Hash, tx.To, tx.Status )
 
-	amount, err := GetRealAmountStr( tx.Value ,bs.wm.Decimal() )
-	if err!=nil {
+	value, err := filecoinTransaction.ParseAttoFIL(tx.Value)
+	if err != nil {
 		bs.wm.Log.Std.Error("transfer tx.Value error, ", err)
 		return
 	}
-	gasDec, err := GetRealAmountDec( tx.Gas ,bs.wm.Decimal() )
-	if err!=nil {
-		bs.wm.Log.Std.Error("transfer tx.Gas error, ", err)
+	amount := value.Unitless()
+	gas, ok := new(big.Int).SetString(tx.Gas, 10)
+	if !ok {
+		bs.wm.Log.Std.Error("transfer tx.Gas error, invalid gas %s", tx.Gas)
 		return
 	}
-	gasPriceDec, err := GetRealAmountDec( tx.GasPrice ,bs.wm.Decimal() )
-	if err!=nil {
+	gasPrice, err := filecoinTransaction.ParseAttoFIL(tx.GasPrice)
+	if err != nil {
 		bs.wm.Log.Std.Error("transfer tx.GasPrice error, ", err)
 		return
 	}
-	fee := gasDec.Mul( gasPriceDec )
+	fee, err := gasPrice.Mul(gas)
+	if err != nil {
+		bs.wm.Log.Std.Error("transfer tx.Gas error, ", err)
+		return
+	}
 
 	coin := openwallet.Coin{
 		Symbol:     bs.wm.Symbol(),
@@ -606,7 +642,7 @@ func (bs *FILBlockScanner) InitExtractResult(sourceKey string, tx *Transaction,
 	to := tx.To
 
 	transx := &openwallet.Transaction{
-		Fees:        fee.String(),
+		Fees:        fee.Unitless(),
 		Coin:        coin,
 		BlockHash:   result.BlockHash,
 		BlockHeight: result.BlockHeight,
@@ -621,6 +657,14 @@ func (bs *FILBlockScanner) InitExtractResult(sourceKey string, tx *Transaction,
 		TxType:      0,
 	}
 
+	if len(tx.FromID) > 0 || len(tx.ToID) > 0 {
+		extParam, _ := json.Marshal(map[string]string{
+			"fromID": tx.FromID,
+			"toID":   tx.ToID,
+		})
+		transx.ExtParam = string(extParam)
+	}
+
 	wxID := openwallet.GenTransactionWxID(transx)
 	transx.WxID = wxID
 
@@ -867,7 +911,7 @@ func (bs *FILBlockScanner) GetBalanceByAddress(address ...string) ([]*openwallet
 		addrsBalance = append(addrsBalance, &openwallet.Balance{
 			Symbol:  bs.wm.Symbol(),
 			Address: addr,
-			Balance: common.BigIntToDecimals(balance.Balance, bs.wm.Decimal()).String(),
+			Balance: formatAttoFIL(balance.Balance),
 		})
 	}
 
@@ -951,6 +995,12 @@ func (bs *FILBlockScanner) Stop() error {
 
 	bs.BlockScannerBase.Stop()
 
+	if bs.wm.AddressResolver != nil {
+		if err := bs.wm.AddressResolver.Close(); err != nil {
+			bs.wm.Log.Std.Error("close address db failed, err=%v", err)
+		}
+	}
+
 	return nil
 }
 
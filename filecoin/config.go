@@ -17,6 +17,7 @@ package filecoin
 import (
 	"github.com/blocktree/go-owcrypt"
 	"github.com/blocktree/openwallet/v2/common/file"
+	"github.com/shopspring/decimal"
 	"math/big"
 	"path/filepath"
 	"strings"
@@ -66,11 +67,41 @@ type WalletConfig struct {
 	GasFeeCapAdd *big.Int
 
 	NonceDiff uint64
+
+	//发送给矿工actor的策略，warn：只记录警告，reject：拒绝创建交易
+	MinerSendPolicy string
+
+	//离线构建使用的手续费档位
+	FeeProfiles map[string]*FeeProfile
+
+	//没有单个地址余额足够时，是否允许由多个地址分别出金
+	MultiSourceWithdraw bool
+
+	//发送地址选择策略：largestBalance、smallestCovering、fewestPending、roundRobin
+	SenderSelector string
+
+	//手续费档次，交易单未指定时使用 DefaultFeeTier
+	FeeTiers       map[string]*FeeTier
+	DefaultFeeTier string
+
+	//汇总保护：base fee（attoFIL/gas）超过 SummaryMaxBaseFee 时推迟汇总，手续费超过汇总金额的 SummaryMaxFeePercent% 时跳过该地址，0为不限制
+	SummaryMaxBaseFee    *big.Int
+	SummaryMaxFeePercent decimal.Decimal
+
+	//发送地址锁：等待加锁的秒数，构建成功后未广播时持有的秒数
+	AddressLockTimeout uint64
+	AddressLockLease   uint64
+
+	//已广播消息不在内存池也未上链超过 RebroadcastEpochs 个高度时重新广播，最多 MaxRebroadcasts 次，之后视为丢弃
+	RebroadcastEpochs uint64
+	MaxRebroadcasts   int
 }
 
 func NewConfig() *WalletConfig {
 	c := WalletConfig{}
 	c.CurveType = CurveType
+	c.RebroadcastEpochs = DefaultRebroadcastEpochs
+	c.MaxRebroadcasts = DefaultMaxRebroadcasts
 	return &c
 }
 
@@ -0,0 +1,177 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package filecoin
+
+import (
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+
+	"github.com/blocktree/openwallet/v2/openwallet"
+	"github.com/shopspring/decimal"
+	"github.com/tidwall/gjson"
+)
+
+const (
+	FeeTierEconomy = "economy" //低优先级，限制单条消息的最高手续费
+	FeeTierNormal  = "normal"  //节点估算值加配置的增量，与原有行为一致
+	FeeTierUrgent  = "urgent"  //提高gas limit余量与premium
+
+	ExtParamFeeTier = "feeTier" //交易单使用的手续费档次
+)
+
+//FeeTier 手续费策略档次，在节点估算值的基础上调整gas limit与premium，并限制fee cap与单条消息的最高手续费
+type FeeTier struct {
+	Name            string
+	GasLimitPercent int64    //gas limit 相对估算值的比例（%）
+	PremiumPercent  int64    //gas premium 相对估算值的比例（%）
+	FeeCapMax       *big.Int //gas fee cap 上限，0 为不限制
+	MaxFee          *big.Int //单条消息的最高手续费（gasLimit * gasFeeCap），0 为不限制
+}
+
+//defaultFeeTiers 内置的手续费档次，可被 feeTiers 配置覆盖
+func defaultFeeTiers() map[string]*FeeTier {
+	return map[string]*FeeTier{
+		FeeTierEconomy: {Name: FeeTierEconomy, GasLimitPercent: 100, PremiumPercent: 100, FeeCapMax: big.NewInt(0), MaxFee: big.NewInt(10000000000000000)},
+		FeeTierNormal:  {Name: FeeTierNormal, GasLimitPercent: 100, PremiumPercent: 100, FeeCapMax: big.NewInt(0), MaxFee: big.NewInt(0)},
+		FeeTierUrgent:  {Name: FeeTierUrgent, GasLimitPercent: 110, PremiumPercent: 200, FeeCapMax: big.NewInt(0), MaxFee: big.NewInt(0)},
+	}
+}
+
+//parseFeeTiers 解析手续费档次配置，格式：name:gasLimitPercent:premiumPercent:feeCapMax:maxFee,name:...
+//feeCapMax、maxFee 单位为attoFIL，配置中的档次覆盖同名的内置档次
+func parseFeeTiers(value string) (map[string]*FeeTier, error) {
+	tiers := defaultFeeTiers()
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		fields := strings.Split(item, ":")
+		if len(fields) != 5 || fields[0] == "" {
+			return nil, fmt.Errorf("fee tier %q should be name:gasLimitPercent:premiumPercent:feeCapMax:maxFee", item)
+		}
+		tier := &FeeTier{Name: fields[0]}
+		percents := make([]int64, 2)
+		for i, field := range fields[1:3] {
+			v, err := strconv.ParseInt(field, 10, 64)
+			if err != nil || v <= 0 {
+				return nil, fmt.Errorf("fee tier %q has invalid percent %q", item, field)
+			}
+			percents[i] = v
+		}
+		limits := make([]*big.Int, 2)
+		for i, field := range fields[3:] {
+			v, ok := new(big.Int).SetString(field, 10)
+			if !ok || v.Sign() < 0 {
+				return nil, fmt.Errorf("fee tier %q has invalid number %q", item, field)
+			}
+			limits[i] = v
+		}
+		tier.GasLimitPercent, tier.PremiumPercent = percents[0], percents[1]
+		tier.FeeCapMax, tier.MaxFee = limits[0], limits[1]
+		tiers[tier.Name] = tier
+	}
+	return tiers, nil
+}
+
+//GetFeeTier 按名称获取手续费档次，名称为空时使用默认档次
+func (wm *WalletManager) GetFeeTier(name string) (*FeeTier, error) {
+	if name == "" {
+		name = wm.Config.DefaultFeeTier
+	}
+	tier, ok := wm.Config.FeeTiers[name]
+	if !ok {
+		return nil, fmt.Errorf("fee tier %s is not configured", name)
+	}
+	return tier, nil
+}
+
+//feeTierName 交易单的 feeTier 扩展参数优先；FeeRate 为档次名称时同样有效，数字形式的 FeeRate 忽略
+func feeTierName(feeRate, extParam string) string {
+	if v := gjson.Get(extParam, ExtParamFeeTier); v.Exists() {
+		return v.String()
+	}
+	feeRate = strings.TrimSpace(feeRate)
+	if _, err := decimal.NewFromString(feeRate); err == nil {
+		return ""
+	}
+	return feeRate
+}
+
+//rawTxFeeTier 交易单使用的手续费档次
+func (decoder *TransactionDecoder) rawTxFeeTier(rawTx *openwallet.RawTransaction) (*FeeTier, error) {
+	tier, err := decoder.wm.GetFeeTier(feeTierName(rawTx.FeeRate, rawTx.ExtParam))
+	if err != nil {
+		return nil, openwallet.Errorf(openwallet.ErrCreateRawTransactionFailed, "%v", err)
+	}
+	return tier, nil
+}
+
+//sumRawTxFeeTier 汇总交易使用的手续费档次
+func (decoder *TransactionDecoder) sumRawTxFeeTier(sumRawTx *openwallet.SummaryRawTransaction) (*FeeTier, error) {
+	tier, err := decoder.wm.GetFeeTier(feeTierName(sumRawTx.FeeRate, sumRawTx.ExtParam))
+	if err != nil {
+		return nil, openwallet.Errorf(openwallet.ErrCreateRawTransactionFailed, "%v", err)
+	}
+	return tier, nil
+}
+
+//maxFeeSpec 估算时传给节点的 MaxFee，"0" 使用节点的默认值
+func (t *FeeTier) maxFeeSpec() string {
+	if t.MaxFee == nil || t.MaxFee.Sign() <= 0 {
+		return "0"
+	}
+	return t.MaxFee.String()
+}
+
+//apply 按档次调整估算的gas：先按比例放大gas limit与premium，再限制fee cap，premium不超过fee cap
+func (t *FeeTier) apply(gasLimit, gasPremium, gasFeeCap *big.Int) (*big.Int, *big.Int, *big.Int) {
+	gasLimit = percentOf(gasLimit, t.GasLimitPercent)
+	gasPremium = percentOf(gasPremium, t.PremiumPercent)
+	gasFeeCap = maxBigInt(gasFeeCap, gasPremium)
+
+	if t.FeeCapMax != nil && t.FeeCapMax.Sign() > 0 && gasFeeCap.Cmp(t.FeeCapMax) > 0 {
+		gasFeeCap = new(big.Int).Set(t.FeeCapMax)
+	}
+	if t.MaxFee != nil && t.MaxFee.Sign() > 0 && gasLimit.Sign() > 0 {
+		maxFeeCap := new(big.Int).Div(t.MaxFee, gasLimit)
+		if gasFeeCap.Cmp(maxFeeCap) > 0 {
+			gasFeeCap = maxFeeCap
+		}
+	}
+	if gasPremium.Cmp(gasFeeCap) > 0 {
+		gasPremium = new(big.Int).Set(gasFeeCap)
+	}
+	return gasLimit, gasPremium, gasFeeCap
+}
+
+//check 检查手续费是否在档次的限制之内，用于不能按档次下调的替换消息
+func (t *FeeTier) check(feeInfo *txFeeInfo) error {
+	if t.FeeCapMax != nil && t.FeeCapMax.Sign() > 0 && feeInfo.GasFeeCap.Cmp(t.FeeCapMax) > 0 {
+		return fmt.Errorf("gas fee cap %s exceeds the %s tier limit %s", feeInfo.GasFeeCap, t.Name, t.FeeCapMax)
+	}
+	if t.MaxFee != nil && t.MaxFee.Sign() > 0 && feeInfo.Fee.Cmp(t.MaxFee) > 0 {
+		return fmt.Errorf("fee %s exceeds the %s tier max fee %s", formatAttoFIL(feeInfo.Fee), t.Name, formatAttoFIL(t.MaxFee))
+	}
+	return nil
+}
+
+func percentOf(v *big.Int, percent int64) *big.Int {
+	r := new(big.Int).Mul(v, big.NewInt(percent))
+	return r.Div(r, big.NewInt(100))
+}
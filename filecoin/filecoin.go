@@ -17,9 +17,12 @@ package filecoin
 import (
 	"github.com/astaxie/beego/config"
 	"github.com/blocktree/filecoin-adapter/filecoin_addrdec"
+	"github.com/blocktree/filecoin-adapter/filecoinTransaction"
 	"github.com/blocktree/openwallet/v2/log"
 	"github.com/blocktree/openwallet/v2/openwallet"
 	"github.com/blocktree/filecoin-adapter/filecoin_rpc"
+	"fmt"
+	"github.com/shopspring/decimal"
 	"math/big"
 )
 
@@ -76,11 +79,11 @@ func (wm *WalletManager) LoadAssetsConfig(c config.Configer) error {
 
 	wm.Config.Symbol = c.String("symbol")
 
-	decimalInt, err := c.Int("decimal")
-	if err!=nil {
-		decimalInt = 18
+	//FIL的精度固定为18位，配置值不同时以链上精度为准
+	wm.Config.Decimal = filecoinTransaction.FILDecimals
+	if decimalInt, err := c.Int("decimal"); err == nil && int32(decimalInt) != filecoinTransaction.FILDecimals {
+		wm.Log.Warningf("decimal %d in config is ignored, FIL always uses %d decimals", decimalInt, filecoinTransaction.FILDecimals)
 	}
-	wm.Config.Decimal = int32(decimalInt)
 
 	wm.Config.LessSumDiff = uint64(300)
 
@@ -124,6 +127,94 @@ func (wm *WalletManager) LoadAssetsConfig(c config.Configer) error {
 	}
 	wm.Config.NonceDiff = uint64(nonceDiffInt)
 
+	addressLockTimeout, err := c.Int64("addressLockTimeout")
+	if err != nil || addressLockTimeout <= 0 {
+		addressLockTimeout = DefaultAddressLockTimeout
+	}
+	wm.Config.AddressLockTimeout = uint64(addressLockTimeout)
+	addressLockLease, err := c.Int64("addressLockLease")
+	if err != nil || addressLockLease <= 0 {
+		addressLockLease = DefaultAddressLockLease
+	}
+	wm.Config.AddressLockLease = uint64(addressLockLease)
+
+	rebroadcastEpochs, err := c.Int64("rebroadcastEpochs")
+	if err != nil || rebroadcastEpochs <= 0 {
+		rebroadcastEpochs = DefaultRebroadcastEpochs
+	}
+	wm.Config.RebroadcastEpochs = uint64(rebroadcastEpochs)
+	maxRebroadcasts, err := c.Int("maxRebroadcasts")
+	if err != nil || maxRebroadcasts < 0 {
+		maxRebroadcasts = DefaultMaxRebroadcasts
+	}
+	wm.Config.MaxRebroadcasts = maxRebroadcasts
+
+	wm.Config.MultiSourceWithdraw, _ = c.Bool("multiSourceWithdraw")
+
+	wm.Config.SenderSelector = c.String("senderSelector")
+	senderSelector, err := NewSenderSelector(wm.Config.SenderSelector)
+	if err != nil {
+		return err
+	}
+	wm.SenderSelector = senderSelector
+
+	wm.Config.MinerSendPolicy = c.String("minerSendPolicy")
+	if wm.Config.MinerSendPolicy != MinerSendPolicyReject {
+		wm.Config.MinerSendPolicy = MinerSendPolicyWarn
+	}
+
+	feeProfiles, err := parseFeeProfiles(c.String("feeProfiles"))
+	if err != nil {
+		return err
+	}
+	wm.Config.FeeProfiles = feeProfiles
+
+	feeTiers, err := parseFeeTiers(c.String("feeTiers"))
+	if err != nil {
+		return err
+	}
+	wm.Config.FeeTiers = feeTiers
+	wm.Config.DefaultFeeTier = c.String("defaultFeeTier")
+	if wm.Config.DefaultFeeTier == "" {
+		wm.Config.DefaultFeeTier = FeeTierNormal
+	}
+	if _, ok := feeTiers[wm.Config.DefaultFeeTier]; !ok {
+		return fmt.Errorf("default fee tier %s is not configured", wm.Config.DefaultFeeTier)
+	}
+
+	wm.Config.SummaryMaxBaseFee = big.NewInt(0)
+	if v := c.String("summaryMaxBaseFee"); v != "" {
+		if _, ok := wm.Config.SummaryMaxBaseFee.SetString(v, 10); !ok || wm.Config.SummaryMaxBaseFee.Sign() < 0 {
+			return fmt.Errorf("invalid summaryMaxBaseFee %s", v)
+		}
+	}
+	wm.Config.SummaryMaxFeePercent = decimal.Zero
+	if v := c.String("summaryMaxFeePercent"); v != "" {
+		percent, err := decimal.NewFromString(v)
+		if err != nil || percent.IsNegative() {
+			return fmt.Errorf("invalid summaryMaxFeePercent %s", v)
+		}
+		wm.Config.SummaryMaxFeePercent = percent
+	}
+
+	baseFeeHistorySize, err := c.Int("baseFeeHistorySize")
+	if err != nil || baseFeeHistorySize <= 0 {
+		baseFeeHistorySize = DefaultBaseFeeHistorySize
+	}
+	wm.BaseFeeHistory = NewBaseFeeHistory(baseFeeHistorySize)
+
+	//f410地址签名使用的eth chain id
+	chainID, err := c.Int64("chainID")
+	if err != nil || chainID <= 0 {
+		if wm.Config.isTestNet {
+			wm.Config.ChainID = filecoinTransaction.EthChainIDCalibnet
+		} else {
+			wm.Config.ChainID = filecoinTransaction.EthChainIDMainnet
+		}
+	} else {
+		wm.Config.ChainID = uint64(chainID)
+	}
+
 	return nil
 
 }
@@ -0,0 +1,113 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package filecoin
+
+import (
+	"encoding/hex"
+	"fmt"
+
+	"github.com/blocktree/filecoin-adapter/filecoinTransaction"
+	"github.com/blocktree/openwallet/v2/openwallet"
+)
+
+//ImportKeyInfo 解析 lotus wallet export 导出的私钥，返回私钥信息与对应地址
+func (wm *WalletManager) ImportKeyInfo(exported string) (*filecoinTransaction.KeyInfo, string, error) {
+	ki, err := filecoinTransaction.ParseKeyInfo(exported)
+	if err != nil {
+		return nil, "", err
+	}
+
+	pub, err := ki.PublicKey()
+	if err != nil {
+		return nil, "", err
+	}
+
+	address, err := wm.Decoder.AddressEncode(pub)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return ki, address, nil
+}
+
+//ExportKeyInfo 把钱包地址的私钥导出为 lotus wallet import 可用的格式
+func (wm *WalletManager) ExportKeyInfo(wrapper openwallet.WalletDAI, address string) (string, error) {
+	ki, err := wm.getAddressKeyInfo(wrapper, address)
+	if err != nil {
+		return "", err
+	}
+	return ki.Export()
+}
+
+//SignMessage 用钱包地址的私钥签名任意数据，返回值与 lotus wallet sign 输出一致
+func (wm *WalletManager) SignMessage(wrapper openwallet.WalletDAI, address string, data []byte) (string, error) {
+	ki, err := wm.getAddressKeyInfo(wrapper, address)
+	if err != nil {
+		return "", err
+	}
+	sig, err := filecoinTransaction.SignMessage(ki, data)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(sig), nil
+}
+
+//VerifyMessage 校验 lotus wallet sign 格式的签名是否由地址签出，支持f1/f3地址
+func (wm *WalletManager) VerifyMessage(address string, data []byte, signature string) error {
+	sig, err := hex.DecodeString(signature)
+	if err != nil {
+		return fmt.Errorf("invalid signature hex: %v", err)
+	}
+	return filecoinTransaction.VerifyMessage(address, data, sig)
+}
+
+//getAddressKeyInfo 按地址的HD路径派生私钥
+func (wm *WalletManager) getAddressKeyInfo(wrapper openwallet.WalletDAI, address string) (*filecoinTransaction.KeyInfo, error) {
+	addr, err := wrapper.GetAddress(address)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := wrapper.HDKey()
+	if err != nil {
+		return nil, err
+	}
+
+	childKey, err := key.DerivedKeyWithPath(addr.HDPath, wm.Config.CurveType)
+	if err != nil {
+		return nil, err
+	}
+	keyBytes, err := childKey.GetPrivateKeyBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	ki, err := filecoinTransaction.NewKeyInfo(filecoinTransaction.KTSecp256k1, keyBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	//确认私钥与地址一致，避免用错误的私钥导出或签名
+	derived, err := ki.Address(wm.Config.isTestNet)
+	if err != nil {
+		return nil, err
+	}
+	if derived != address {
+		return nil, fmt.Errorf("derived address %s does not match %s", derived, address)
+	}
+
+	return ki, nil
+}
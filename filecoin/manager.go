@@ -24,11 +24,8 @@ import (
 	"github.com/blocktree/filecoin-adapter/filecoinTransaction"
 	"github.com/blocktree/filecoin-adapter/filecoin_addrdec"
 	"github.com/blocktree/go-owcrypt"
-	"github.com/blocktree/openwallet/v2/common"
 	"github.com/blocktree/openwallet/v2/log"
 	"github.com/blocktree/openwallet/v2/openwallet"
-	"github.com/ethereum/go-ethereum/common/math"
-	"github.com/filecoin-project/go-state-types/crypto"
 	"github.com/filecoin-project/specs-actors/actors/builtin"
 	"github.com/tidwall/gjson"
 	"math/big"
@@ -52,6 +49,14 @@ type WalletManager struct {
 	Log                     *log.OWLogger                   //日志工具
 	CustomAddressEncodeFunc func(address string) string     //自定义地址转换算法
 	CustomAddressDecodeFunc func(address string) string     //自定义地址转换算法
+	AddressResolver         *AddressResolver                //ID地址解析器
+	ActorCodes              *ActorCodeRegistry              //actor类型注册表
+	SenderSelector          SenderSelector                  //发送地址选择策略
+	BaseFeeHistory          *BaseFeeHistory                 //近期tipset的base fee
+	NonceManager            *NonceManager                   //发送地址nonce的分配与跟踪
+	AddressLocker           *AddressLocker                  //发送地址锁，串行化同一地址的构建与广播
+	MessageTracker          *MessageTracker                 //已广播消息的上链结果跟踪
+	MpoolInspector          *MpoolInspector                 //我方地址在内存池中的消息检查
 }
 
 func NewWalletManager() *WalletManager {
@@ -63,6 +68,16 @@ func NewWalletManager() *WalletManager {
 	wm.Log = log.NewOWLogger(wm.Symbol())
 	wm.CustomAddressEncodeFunc = CustomAddressEncode
 	wm.CustomAddressDecodeFunc = CustomAddressDecode
+	wm.AddressResolver = NewAddressResolver(&wm)
+	wm.ActorCodes = NewActorCodeRegistry(&wm)
+	wm.SenderSelector = &SmallestCoveringSelector{}
+	wm.Config.FeeTiers = defaultFeeTiers()
+	wm.Config.DefaultFeeTier = FeeTierNormal
+	wm.BaseFeeHistory = NewBaseFeeHistory(DefaultBaseFeeHistorySize)
+	wm.NonceManager = NewNonceManager(&wm)
+	wm.AddressLocker = NewAddressLocker(&wm)
+	wm.MessageTracker = NewMessageTracker(&wm)
+	wm.MpoolInspector = NewMpoolInspector(&wm)
 
 	return &wm
 }
@@ -591,8 +606,13 @@ func (wm *WalletManager) GetAddrBalance(address string) (*AddrBalance, error) {
 
 	balance, _ := big.NewInt(0).SetString( result.Get("Balance").Str, 10)
 	nonce := uint64(result.Get("Nonce").Uint())
-	realBalance := common.BigIntToDecimals(balance, wm.Decimal() )
-	return &AddrBalance{Address: address, Balance: balance, RealBalance: &realBalance, Nonce: nonce}, nil
+	code := result.Get("Code./").String()
+	amount, err := filecoinTransaction.NewFIL(balance)
+	if err != nil {
+		return nil, err
+	}
+	realBalance := amount.Decimal()
+	return &AddrBalance{Address: address, Balance: balance, RealBalance: &realBalance, Nonce: nonce, Code: code}, nil
 
 	//params := []interface{}{
 	//	address,
@@ -663,21 +683,22 @@ func (wm *WalletManager) GetTransactionReceipt(txCid string) (int64, int64, erro
 }
 
 func (wm *WalletManager) SendRawTransaction( message *filecoinTransaction.Message, signature, accessToken string) (string, error){
-	//js, _ := json.Marshal(message)
-	//fmt.Println("js : ", string(js))
-
-	sigData, _ := hex.DecodeString(signature)
-	sig := crypto.Signature{
-		Type: 1,
-		Data: sigData,
+	sigData, err := hex.DecodeString(signature)
+	if err != nil {
+		return "", fmt.Errorf("invalid signature hex: %v", err)
 	}
 
-	callMsg := map[string]interface{}{
-		"message" :  message,
-		"signature" : sig,
+	signedMsg, err := filecoinTransaction.NewSignedMessage(message, sigData)
+	if err != nil {
+		return "", err
 	}
 
-	params := []interface{}{ callMsg }
+	return wm.SendSignedMessage(signedMsg, accessToken)
+}
+
+//SendSignedMessage 广播已签名消息，返回节点给出的消息CID
+func (wm *WalletManager) SendSignedMessage(signedMsg *filecoinTransaction.SignedMessage, accessToken string) (string, error) {
+	params := []interface{}{ signedMsg }
 
 	result, err := wm.WalletClient.CallWithToken(accessToken, "Filecoin.MpoolPush", params)
 
@@ -737,7 +758,8 @@ func (wm *WalletManager) GetEstimateFeeCap(msg interface{}) (*big.Int, error) {
 	return gasFeeCap, nil
 }
 
-func (wm *WalletManager) GetMpoolPending() (error) {
+//GetMpoolPending 获取内存池中全部待打包的消息
+func (wm *WalletManager) GetMpoolPending() ([]*MpoolMessage, error) {
 	blockCids := make([]interface{}, 0)
 
 	params := []interface{}{
@@ -745,21 +767,37 @@ func (wm *WalletManager) GetMpoolPending() (error) {
 	}
 	result, err := wm.WalletClient.Call("Filecoin.MpoolPending", params)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
+	messages := make([]*MpoolMessage, 0)
 	for _, signedMessage := range result.Array() {
-		message := gjson.Get( signedMessage.Raw, "Message")
-		method := gjson.Get( message.Raw, "Method").Int()
-		if method==0 {
-			fmt.Println( message.String() )
+		message, err := wm.parseMpoolMessage(signedMessage)
+		if err != nil {
+			wm.Log.Warningf("skip mpool message: %v", err)
+			continue
 		}
+		messages = append(messages, message)
 	}
 
-	return nil
+	return messages, nil
 }
 
 func (wm *WalletManager) GetTransactionFeeEstimated(from string, to string, value *big.Int, nonce uint64) (*txFeeInfo, error) {
+	return wm.GetTransactionFeeEstimatedWithMethod(from, to, value, nonce, uint64(builtin.MethodSend), nil)
+}
+
+//GetTransactionFeeEstimatedWithMethod 按指定的方法号与参数估算手续费，使用默认的手续费档次
+func (wm *WalletManager) GetTransactionFeeEstimatedWithMethod(from string, to string, value *big.Int, nonce uint64, method uint64, methodParams []byte) (*txFeeInfo, error) {
+	tier, err := wm.GetFeeTier("")
+	if err != nil {
+		return nil, err
+	}
+	return wm.GetTransactionFeeEstimatedWithTier(from, to, value, nonce, method, methodParams, tier)
+}
+
+//GetTransactionFeeEstimatedWithTier 按手续费档次估算手续费，档次的 MaxFee 同时传给节点
+func (wm *WalletManager) GetTransactionFeeEstimatedWithTier(from string, to string, value *big.Int, nonce uint64, method uint64, methodParams []byte, tier *FeeTier) (*txFeeInfo, error) {
 	var (
 		gasLimit *big.Int
 		gasPrice *big.Int
@@ -774,12 +812,15 @@ func (wm *WalletManager) GetTransactionFeeEstimated(from string, to string, valu
 		//"gasPremium" : gasPremium.String(),
 		"nonce" : nonce,
 		//"gasLimit" : gasLimit,
-		"method" : builtin.MethodSend,
+		"method" : method,
+	}
+	if len(methodParams) > 0 {
+		msg["params"] = base64.StdEncoding.EncodeToString(methodParams)
 	}
 
 	//----------直接获取----------
 	sendSpec := map[string]interface{}{
-		"MaxFee" : "0",
+		"MaxFee" : tier.maxFeeSpec(),
 	}
 
 	blockCids := make([]interface{}, 0)
@@ -794,16 +835,23 @@ func (wm *WalletManager) GetTransactionFeeEstimated(from string, to string, valu
 		return nil, err
 	}
 
-	gasLimitStr := gjson.Get(msgInfoJson.Raw, "GasLimit").String()
-	gasLimit, _ = big.NewInt(0).SetString(gasLimitStr, 10)
+	//GasFeeCap、GasPremium 为字符串形式的大整数，直接解析为 big.Int，不经过 int64/float64
+	gasLimit, ok := big.NewInt(0).SetString(gjson.Get(msgInfoJson.Raw, "GasLimit").String(), 10)
+	if !ok {
+		return nil, fmt.Errorf("invalid GasLimit in estimate result: %s", msgInfoJson.Raw)
+	}
 	gasLimit = gasLimit.Add( gasLimit, wm.Config.GasLimitAdd )
 
-	gasPremiumStr := gjson.Get(msgInfoJson.Raw, "GasPremium").String()
-	gasPremium, _ := big.NewInt(0).SetString(gasPremiumStr, 10)
+	gasPremium, ok := big.NewInt(0).SetString(gjson.Get(msgInfoJson.Raw, "GasPremium").String(), 10)
+	if !ok {
+		return nil, fmt.Errorf("invalid GasPremium in estimate result: %s", msgInfoJson.Raw)
+	}
 	gasPremium = gasPremium.Add( gasPremium, wm.Config.GasPremiumAdd )
 
-	gasFeeCapStr := gjson.Get(msgInfoJson.Raw, "GasFeeCap").String()
-	gasFeeCap, _ := big.NewInt(0).SetString(gasFeeCapStr, 10)
+	gasFeeCap, ok := big.NewInt(0).SetString(gjson.Get(msgInfoJson.Raw, "GasFeeCap").String(), 10)
+	if !ok {
+		return nil, fmt.Errorf("invalid GasFeeCap in estimate result: %s", msgInfoJson.Raw)
+	}
 	gasFeeCap = gasFeeCap.Add( gasFeeCap, wm.Config.GasFeeCapAdd )
 
 	//----------分步获取----------
@@ -836,6 +884,8 @@ func (wm *WalletManager) GetTransactionFeeEstimated(from string, to string, valu
 		gasPremium = gasPremium.Add( gasPremium, big.NewInt(5000000) )
 	}
 
+	gasLimit, gasPremium, gasFeeCap = tier.apply(gasLimit, gasPremium, gasFeeCap)
+
 	feeInfo := &txFeeInfo{
 		GasLimit: gasLimit,
 		GasPrice: gasPrice,
@@ -913,62 +963,32 @@ func CustomAddressDecode(address string) string {
 	return address
 }
 
-// GetAddressNonce
+//GetAddressNonce 获取地址下一笔交易可用的nonce
+//Deprecated: nonce 由 NonceManager 统一分配，请使用 NonceManager.Reserve 预留nonce
 func (wm *WalletManager) GetAddressNonce(wrapper openwallet.WalletDAI, address string, nonce_onchain uint64) uint64 {
-	var (
-		key           = wm.Symbol() + "-nonce"
-		nonce         uint64
-		nonce_db      interface{}
-	)
-
-	//获取db记录的nonce并确认nonce值
-	nonce_db, _ = wrapper.GetAddressExtParam(address, key)
-
-	//判断nonce_db是否为空,为空则说明当前nonce是0
-	if nonce_db == nil {
-		nonce = 0
-	} else {
-		//nonce = common.NewString(nonce_db).UInt64()
-		nonceStr := common.NewString(nonce_db)
-		nonceStrArr := strings.Split(string(nonceStr), "_")
-
-		if len(nonceStrArr)>1 {
-			saveTime := common.NewString(nonceStrArr[1]).UInt64()
-			now := uint64(time.Now().Unix())
-			diff, _ := math.SafeSub(now, saveTime)
-
-			if diff > wm.Config.NonceDiff { //当前时间减去保存时间，超过1小时，就不算了
-				nonce = 0
-			} else {
-				nonce = common.NewString(nonceStrArr[0]).UInt64()
-			}
-		}else{
-			nonce = common.NewString(nonce_db).UInt64()
-		}
+	_, next, err := wm.NonceManager.Peek(address)
+	if err != nil {
+		wm.Log.Errorf("get %s nonce failed, err: %v", address, err)
+		return nonce_onchain
 	}
 
-	wm.Log.Info(address, " get nonce : ", nonce, ", nonce_onchain : ", nonce_onchain)
-
-	//如果本地nonce_db > 链上nonce,采用本地nonce,否则采用链上nonce
-	if nonce > nonce_onchain {
-		//wm.Log.Debugf("%s nonce_db=%v > nonce_chain=%v,Use nonce_db...", address, nonce_db, nonce_onchain)
-	} else {
-		nonce = nonce_onchain
-		//wm.Log.Debugf("%s nonce_db=%v <= nonce_chain=%v,Use nonce_chain...", address, nonce_db, nonce_onchain)
+	//如果本地记录的nonce > 链上nonce,采用本地nonce,否则采用链上nonce
+	if next > nonce_onchain {
+		return next
 	}
-
-	return nonce
+	return nonce_onchain
 }
 
-// UpdateAddressNonce
+//UpdateAddressNonce 记录地址的nonce到钱包扩展参数
+//Deprecated: 广播结果由 NonceManager 记录，适配器不再读取此扩展参数
 func (wm *WalletManager) UpdateAddressNonce(wrapper openwallet.WalletDAI, address string, nonce uint64) {
 	key := wm.Symbol() + "-nonce"
 
-	nonceStr := strconv.FormatUint(nonce, 10) + "_" + strconv.FormatUint( uint64(time.Now().Unix()), 10)
+	nonceStr := strconv.FormatUint(nonce, 10) + "_" + strconv.FormatUint(uint64(time.Now().Unix()), 10)
 	wm.Log.Info(address, " set nonce ", nonceStr)
 
 	err := wrapper.SetAddressExtParam(address, key, nonceStr)
 	if err != nil {
 		wm.Log.Errorf("WalletDAI SetAddressExtParam failed, err: %v", err)
 	}
-}
\ No newline at end of file
+}
@@ -0,0 +1,561 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package filecoin
+
+import (
+	"fmt"
+	"math/big"
+	"path/filepath"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/asdine/storm"
+	"github.com/asdine/storm/q"
+	"github.com/tidwall/gjson"
+)
+
+const (
+	trackerDBFile = "tracker.db" //已广播消息跟踪数据库文件
+
+	MessageStatusPending  = "pending"  //已广播，尚未上链
+	MessageStatusIncluded = "included" //已上链，执行成功
+	MessageStatusFailed   = "failed"   //已上链，exit code 非0
+	MessageStatusDropped  = "dropped"  //nonce已被其他消息使用，或多次重新广播后仍不在内存池中
+
+	blockDelaySecs = 30 //出块间隔
+
+	lookbackNoLimit = -1 //StateSearchMsg 不限制向前查找的高度
+)
+
+//TrackedMessage 已广播、跟踪中的消息
+type TrackedMessage struct {
+	Cid        string `storm:"id"`
+	From       string `storm:"index"`
+	Nonce      uint64
+	Status     string `storm:"index"`
+	Height     uint64 //执行回执所在的高度
+	ExitCode   int64
+	GasUsed    int64
+	FeePaid    string //实际支付的手续费，attoFIL
+	ReplacedBy string //被替换时，上链的替换消息CID
+	Reason     string //丢弃原因
+	SubmitTime int64
+	UpdatedAt  int64
+
+	//已签名消息，上链前保留，用于重新广播
+	RawMessage     string
+	Signature      string
+	Rebroadcasts   int    //重新广播次数
+	LastPushTime   int64  //最近一次广播时间
+	LastPushHeight uint64 //最近一次广播时的链高度，据此判断是否需要重新广播
+}
+
+//IsFinal 是否已是最终状态
+func (m *TrackedMessage) IsFinal() bool {
+	return m.Status != MessageStatusPending
+}
+
+//MessageStatusObserver 消息状态变化的观察者
+type MessageStatusObserver interface {
+	//MessageStatusNotify previous 为变化前的状态，新跟踪的消息为空
+	MessageStatusNotify(previous string, message *TrackedMessage) error
+}
+
+//messageLookup StateSearchMsg 的结果
+type messageLookup struct {
+	Cid      string
+	Height   uint64
+	ExitCode int64
+	GasUsed  int64
+}
+
+//MessageTracker 跟踪已广播消息的上链结果，持久化到本地数据库，状态变化时通知观察者
+type MessageTracker struct {
+	wm        *WalletManager
+	mu        sync.Mutex
+	pollMu    sync.Mutex
+	polling   int32  //PollAsync 是否有未结束的 Poll
+	height    uint64 //区块扫描器最近一次传入的链高度
+	dbMu      sync.Mutex
+	db        *storm.DB
+	loaded    bool
+	pending   map[string]*TrackedMessage
+	observers map[MessageStatusObserver]bool
+}
+
+//NewMessageTracker 创建消息跟踪器
+func NewMessageTracker(wm *WalletManager) *MessageTracker {
+	return &MessageTracker{
+		wm:        wm,
+		pending:   make(map[string]*TrackedMessage),
+		observers: make(map[MessageStatusObserver]bool),
+	}
+}
+
+//AddObserver 添加观察者
+func (t *MessageTracker) AddObserver(obj MessageStatusObserver) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.observers[obj] = true
+}
+
+//RemoveObserver 移除观察者
+func (t *MessageTracker) RemoveObserver(obj MessageStatusObserver) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.observers, obj)
+}
+
+//Track 开始跟踪待广播的消息，保留已签名消息直到上链；须在广播前调用，广播结果未知时仍可重新广播
+func (t *MessageTracker) Track(cid, from string, nonce uint64, rawMessage, signature string) {
+	now := time.Now().Unix()
+	message := &TrackedMessage{
+		Cid:            cid,
+		From:           from,
+		Nonce:          nonce,
+		Status:         MessageStatusPending,
+		SubmitTime:     now,
+		UpdatedAt:      now,
+		RawMessage:     rawMessage,
+		Signature:      signature,
+		LastPushTime:   now,
+		LastPushHeight: atomic.LoadUint64(&t.height),
+	}
+
+	t.mu.Lock()
+	t.load()
+	if _, ok := t.pending[cid]; ok {
+		t.mu.Unlock()
+		return
+	}
+	t.pending[cid] = message
+	t.save(message)
+	t.mu.Unlock()
+
+	t.notify("", message)
+}
+
+//Reject 节点明确拒绝的消息不会上链，结束跟踪并记为 dropped
+func (t *MessageTracker) Reject(cid, reason string) {
+	t.mu.Lock()
+	t.load()
+	message, ok := t.pending[cid]
+	t.mu.Unlock()
+	if !ok {
+		return
+	}
+	updated := *message
+	updated.Status = MessageStatusDropped
+	updated.Reason = reason
+	t.finish(message.Status, &updated)
+}
+
+//Get 查询消息的跟踪记录
+func (t *MessageTracker) Get(cid string) (*TrackedMessage, error) {
+	t.mu.Lock()
+	t.load()
+	message, ok := t.pending[cid]
+	t.mu.Unlock()
+	if ok {
+		copied := *message
+		return &copied, nil
+	}
+
+	db, err := t.openDB()
+	if err != nil {
+		return nil, fmt.Errorf("message %s is not tracked", cid)
+	}
+	var found TrackedMessage
+	if err := db.One("Cid", cid, &found); err != nil {
+		return nil, fmt.Errorf("message %s is not tracked", cid)
+	}
+	return &found, nil
+}
+
+//Pending 跟踪中尚未上链的消息，按地址、nonce排序
+func (t *MessageTracker) Pending() []*TrackedMessage {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.load()
+
+	list := make([]*TrackedMessage, 0, len(t.pending))
+	for _, message := range t.pending {
+		copied := *message
+		list = append(list, &copied)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].From != list[j].From {
+			return list[i].From < list[j].From
+		}
+		return list[i].Nonce < list[j].Nonce
+	})
+	return list
+}
+
+//Poll 查询所有跟踪中消息的上链结果，更新状态并通知观察者；height 为当前链高度，用于判断是否需要重新广播
+func (t *MessageTracker) Poll(height uint64) error {
+	t.pollMu.Lock()
+	defer t.pollMu.Unlock()
+
+	if height > atomic.LoadUint64(&t.height) {
+		atomic.StoreUint64(&t.height, height)
+	}
+
+	messages := t.Pending()
+	if len(messages) == 0 {
+		return nil
+	}
+
+	mpool, err := t.wm.GetMpoolPendingNonces()
+	if err != nil {
+		return err
+	}
+	onChainNonces := make(map[string]uint64)
+
+	for _, message := range messages {
+		lookup, err := t.wm.StateSearchMsg(message.Cid, searchLimit(message.SubmitTime))
+		if err != nil {
+			t.wm.Log.Warningf("search message %s failed: %v", message.Cid, err)
+			continue
+		}
+
+		updated := *message
+		switch {
+		case lookup != nil:
+			if err := t.wm.fillMessageResult(&updated, lookup); err != nil {
+				t.wm.Log.Warningf("get result of message %s failed: %v", message.Cid, err)
+				continue
+			}
+		default:
+			onChain, ok := onChainNonces[message.From]
+			if !ok {
+				onChain, err = t.wm.GetAddrOnChainNonce(message.From)
+				if err != nil {
+					t.wm.Log.Warningf("get nonce of %s failed: %v", message.From, err)
+					continue
+				}
+				onChainNonces[message.From] = onChain
+			}
+			inMpool := false
+			for _, n := range pendingNonces(mpool, message.From) {
+				if n == message.Nonce {
+					inMpool = true
+					break
+				}
+			}
+			switch {
+			case onChain > message.Nonce:
+				updated.Status = MessageStatusDropped
+				updated.Reason = fmt.Sprintf("nonce %d is used by another message", message.Nonce)
+			case inMpool:
+			case !t.rebroadcastDue(&updated, height):
+			case message.RawMessage != "" && message.Rebroadcasts < t.wm.Config.MaxRebroadcasts:
+				//不在内存池也未上链，重新广播，仍为pending
+				t.rebroadcast(&updated, height)
+			default:
+				updated.Status = MessageStatusDropped
+				updated.Reason = fmt.Sprintf("not in mpool after %d rebroadcasts", message.Rebroadcasts)
+			}
+			if updated.Status == MessageStatusDropped {
+				rebroadcastMetrics.Add("dropped", 1)
+			}
+		}
+
+		if updated.Status == MessageStatusPending {
+			continue
+		}
+		t.finish(message.Status, &updated)
+	}
+	return nil
+}
+
+//PollAsync 在单独的goroutine中执行 Poll，不阻塞区块扫描；上一次未结束时跳过本次
+func (t *MessageTracker) PollAsync(height uint64) {
+	if !atomic.CompareAndSwapInt32(&t.polling, 0, 1) {
+		return
+	}
+	go func() {
+		defer atomic.StoreInt32(&t.polling, 0)
+		if err := t.Poll(height); err != nil {
+			t.wm.Log.Warningf("message tracker poll failed: %v", err)
+		}
+	}()
+}
+
+//Wait 阻塞等待跟踪中的消息上链并确认 confidence 个高度（StateWaitMsg），结果同步到跟踪记录
+func (t *MessageTracker) Wait(cid string, confidence int64) (*TrackedMessage, error) {
+	message, err := t.Get(cid)
+	if err != nil {
+		return nil, err
+	}
+	if message.IsFinal() {
+		return message, nil
+	}
+
+	lookup, err := t.wm.StateWaitMsg(cid, confidence, searchLimit(message.SubmitTime))
+	if err != nil {
+		return nil, err
+	}
+	previous := message.Status
+	if err := t.wm.fillMessageResult(message, lookup); err != nil {
+		return nil, err
+	}
+	t.finish(previous, message)
+	return message, nil
+}
+
+//finish 记录最终状态，移出跟踪列表
+func (t *MessageTracker) finish(previous string, message *TrackedMessage) {
+	message.UpdatedAt = time.Now().Unix()
+
+	t.mu.Lock()
+	if _, ok := t.pending[message.Cid]; !ok {
+		//已由其他查询更新
+		t.mu.Unlock()
+		return
+	}
+	delete(t.pending, message.Cid)
+	t.save(message)
+	t.mu.Unlock()
+
+	t.wm.Log.Infof("message %s of %s nonce %d: %s -> %s", message.Cid, message.From, message.Nonce, previous, message.Status)
+	t.notify(previous, message)
+}
+
+func (t *MessageTracker) notify(previous string, message *TrackedMessage) {
+	t.mu.Lock()
+	observers := make([]MessageStatusObserver, 0, len(t.observers))
+	for o := range t.observers {
+		observers = append(observers, o)
+	}
+	t.mu.Unlock()
+
+	for _, o := range observers {
+		copied := *message
+		if err := o.MessageStatusNotify(previous, &copied); err != nil {
+			t.wm.Log.Error("MessageStatusNotify unexpected error:", err)
+		}
+	}
+}
+
+//searchLimit 按广播时间估算向前查找的高度数，留出余量
+func searchLimit(submitTime int64) int64 {
+	return (time.Now().Unix()-submitTime)/blockDelaySecs + 20
+}
+
+//StateSearchMsg 从链头向前查找消息的执行回执，limit 为最多查找的高度数，未上链时返回nil
+//允许返回替换消息的回执，此时结果中的CID与查询的不同
+func (wm *WalletManager) StateSearchMsg(cid string, limit int64) (*messageLookup, error) {
+	params := []interface{}{
+		make([]interface{}, 0),
+		map[string]interface{}{
+			"/": cid,
+		},
+		limit,
+		true,
+	}
+	result, err := wm.WalletClient.Call("Filecoin.StateSearchMsg", params)
+	if err != nil {
+		return nil, err
+	}
+	return parseMessageLookup(result), nil
+}
+
+//StateWaitMsg 阻塞等待消息上链并确认 confidence 个高度
+func (wm *WalletManager) StateWaitMsg(cid string, confidence, limit int64) (*messageLookup, error) {
+	params := []interface{}{
+		map[string]interface{}{
+			"/": cid,
+		},
+		confidence,
+		limit,
+		true,
+	}
+	result, err := wm.WalletClient.Call("Filecoin.StateWaitMsg", params)
+	if err != nil {
+		return nil, err
+	}
+	lookup := parseMessageLookup(result)
+	if lookup == nil {
+		return nil, fmt.Errorf("message %s is not found", cid)
+	}
+	return lookup, nil
+}
+
+func parseMessageLookup(result *gjson.Result) *messageLookup {
+	if result == nil || !result.IsObject() {
+		return nil
+	}
+	return &messageLookup{
+		Cid:      gjson.Get(result.Raw, "Message./").String(),
+		Height:   gjson.Get(result.Raw, "Height").Uint(),
+		ExitCode: gjson.Get(result.Raw, "Receipt.ExitCode").Int(),
+		GasUsed:  gjson.Get(result.Raw, "Receipt.GasUsed").Int(),
+	}
+}
+
+//fillMessageResult 按回执填写上链结果与实际手续费
+func (wm *WalletManager) fillMessageResult(message *TrackedMessage, lookup *messageLookup) error {
+	if lookup.Cid != "" && lookup.Cid != message.Cid {
+		message.Status = MessageStatusDropped
+		message.ReplacedBy = lookup.Cid
+		message.Reason = "replaced by " + lookup.Cid
+		message.Height = lookup.Height
+		return nil
+	}
+
+	msg, err := wm.GetMessageByCid(message.Cid)
+	if err != nil {
+		return err
+	}
+	baseFee, err := wm.inclusionBaseFee(lookup.Height)
+	if err != nil {
+		return err
+	}
+
+	message.Height = lookup.Height
+	message.ExitCode = lookup.ExitCode
+	message.GasUsed = lookup.GasUsed
+	message.FeePaid = messageFeePaid(msg.GasLimit, lookup.GasUsed, msg.GasFeeCap.Int, msg.GasPremium.Int, baseFee).String()
+	if lookup.ExitCode == OK_ExitCode {
+		message.Status = MessageStatusIncluded
+	} else {
+		message.Status = MessageStatusFailed
+	}
+	return nil
+}
+
+//inclusionBaseFee 消息执行时的base fee
+//StateSearchMsg 返回的是执行消息的tipset，即包含消息的tipset的下一个（中间可能有空轮次）；
+//包含消息的tipset中区块的 ParentBaseFee 才是执行时使用的base fee，按执行tipset的父区块读取
+func (wm *WalletManager) inclusionBaseFee(executionHeight uint64) (*big.Int, error) {
+	execution, err := wm.GetTipSetByHeight(executionHeight)
+	if err != nil {
+		return nil, err
+	}
+	if len(execution.Blks) == 0 || len(execution.Blks[0].ParentHashs) == 0 {
+		return nil, fmt.Errorf("tipset %d has no parent blocks", executionHeight)
+	}
+
+	params := []interface{}{
+		map[string]interface{}{
+			"/": execution.Blks[0].ParentHashs[0],
+		},
+	}
+	result, err := wm.WalletClient.Call("Filecoin.ChainGetBlock", params)
+	if err != nil {
+		return nil, err
+	}
+	parent, err := NewBlockHeader(result)
+	if err != nil {
+		return nil, err
+	}
+	baseFee, ok := new(big.Int).SetString(parent.ParentBaseFee, 10)
+	if !ok {
+		return nil, fmt.Errorf("invalid ParentBaseFee %q of inclusion block %s", parent.ParentBaseFee, execution.Blks[0].ParentHashs[0])
+	}
+	return baseFee, nil
+}
+
+//messageFeePaid 发送方实际支付的手续费：base fee 燃烧 + gas超额估算燃烧 + 矿工小费，与lotus的计算一致
+func messageFeePaid(gasLimit, gasUsed int64, feeCap, premium, baseFee *big.Int) *big.Int {
+	baseFeeToPay := baseFee
+	if feeCap.Cmp(baseFee) < 0 {
+		baseFeeToPay = feeCap
+	}
+	minerTip := new(big.Int).Sub(feeCap, baseFeeToPay)
+	if premium.Cmp(minerTip) < 0 {
+		minerTip.Set(premium)
+	}
+	minerTip.Mul(minerTip, big.NewInt(gasLimit))
+
+	burn := big.NewInt(gasUsed + gasToBurn(gasUsed, gasLimit))
+	burn.Mul(burn, baseFeeToPay)
+	return burn.Add(burn, minerTip)
+}
+
+//gasToBurn gas limit 超出实际使用10%以上部分按比例燃烧
+func gasToBurn(gasUsed, gasLimit int64) int64 {
+	const gasOveruseNum, gasOveruseDenom = 11, 10
+	if gasUsed == 0 {
+		return gasLimit
+	}
+	over := gasLimit - (gasOveruseNum*gasUsed)/gasOveruseDenom
+	if over < 0 {
+		return 0
+	}
+	if over > gasUsed {
+		over = gasUsed
+	}
+	burn := big.NewInt(gasLimit - gasUsed)
+	burn.Mul(burn, big.NewInt(over))
+	burn.Div(burn, big.NewInt(gasUsed))
+	return burn.Int64()
+}
+
+//load 首次访问时从数据库加载跟踪中的消息，数据库不可用时下次访问再加载
+func (t *MessageTracker) load() {
+	if t.loaded {
+		return
+	}
+	db, err := t.openDB()
+	if err != nil {
+		return
+	}
+	var list []*TrackedMessage
+	if err := db.Select(q.Eq("Status", MessageStatusPending)).Find(&list); err != nil && err != storm.ErrNotFound {
+		t.wm.Log.Std.Error("load tracked messages failed, err=%v", err)
+		return
+	}
+	for _, message := range list {
+		//加载前已开始跟踪的消息以内存中的为准
+		if _, ok := t.pending[message.Cid]; !ok {
+			t.pending[message.Cid] = message
+		}
+	}
+	t.loaded = true
+}
+
+//openDB 打开跟踪数据库，只打开一次并复用；未配置数据目录时只在内存中记录
+func (t *MessageTracker) openDB() (*storm.DB, error) {
+	t.dbMu.Lock()
+	defer t.dbMu.Unlock()
+
+	if t.db != nil {
+		return t.db, nil
+	}
+	if len(t.wm.Config.DBPath) == 0 {
+		return nil, fmt.Errorf("tracker db path is not setup")
+	}
+	db, err := storm.Open(filepath.Join(t.wm.Config.DBPath, trackerDBFile))
+	if err != nil {
+		return nil, err
+	}
+	t.db = db
+	return t.db, nil
+}
+
+func (t *MessageTracker) save(message *TrackedMessage) {
+	db, err := t.openDB()
+	if err != nil {
+		return
+	}
+	if err := db.Save(message); err != nil {
+		t.wm.Log.Std.Error("save tracked message %s failed, err=%v", message.Cid, err)
+	}
+}
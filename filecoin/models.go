@@ -16,16 +16,13 @@ package filecoin
 
 import (
 	"encoding/json"
-	"errors"
 	"fmt"
-	"github.com/blocktree/openwallet/v2/common"
+	"github.com/blocktree/filecoin-adapter/filecoinTransaction"
 	"github.com/blocktree/openwallet/v2/openwallet"
-	"github.com/ethereum/go-ethereum/common/math"
 	"github.com/shopspring/decimal"
 	"github.com/tidwall/gjson"
 	"math/big"
 	"reflect"
-	"strconv"
 )
 
 const CidLength = 62
@@ -142,6 +139,9 @@ type Transaction struct {
 	OriginTo			 string //原有的目标地址
 	OriginValue			 string //原有的金额
 	OriginMethod		 int64  //原有的method
+
+	FromID				 string //发送者的ID地址(f0...)
+	ToID				 string //接收者的ID地址(f0...)
 }
 
 type MsigTransaction struct{
@@ -161,6 +161,7 @@ type FilBlockHeader struct {
 	ParentWeight    string  `json:"ParentWeight"`
 	Height     		uint64  `json:"Height"`
 	Timestamp       uint64  `json:"Timestamp"`
+	ParentBaseFee   string  `json:"ParentBaseFee"`
 	BlockHeaderCid  string
 	ParentHashs     []string
 }
@@ -229,45 +230,9 @@ func isByte(typ reflect.Type) bool {
 	return typ.Kind() == reflect.Uint8
 }
 
-func GetRealAmountStr(bigIntStr string, amountDecimal int32) (string, error){
-	amountBigInt, ok := big.NewInt(0).SetString( bigIntStr, 10)
-	if !ok {
-		return "", errors.New("大转小错误")
-	}
-	amount_dec := common.BigIntToDecimals( amountBigInt, amountDecimal )
-	amount := amount_dec.Abs().String()
-	return amount, nil
-}
-
-func GetRealAmountDec(bigIntStr string, amountDecimal int32) (decimal.Decimal, error){
-	amountBigInt, ok := big.NewInt(0).SetString( bigIntStr, 10)
-	if !ok {
-		return decimal.Zero, errors.New("decimal大转小错误")
-	}
-	amount_dec := common.BigIntToDecimals( amountBigInt, amountDecimal )
-	return amount_dec, nil
-}
-
-//把真实金额的字符串，转化为最小单位的字符串
-func GetBigIntAmountStr(amountStr string, amountDecimal int32) string{
-	d, _ := decimal.NewFromString(amountStr)
-	ten := math.BigPow(10, int64(amountDecimal) )
-	w, _ := decimal.NewFromString( ten.String() )
-	d = d.Mul(w)
-	return d.String()
-}
-
-// amount 字符串转为最小单位的表示
-func ConvertFromAmount(amountStr string, amountDecimal int32) *big.Int {
-	//bigAmountStr := GetBigIntAmountStr(amountStr, amountDecimal)
-	r := common.StringNumToBigIntWithExp(amountStr, amountDecimal)
-	return r
-}
-
-// amount 字符串转为最小单位的表示
-func OldConvertFromAmount(amountStr string, amountDecimal int32) uint64 {
-	r, _ := strconv.ParseInt(GetBigIntAmountStr(amountStr, amountDecimal), 10, 64)
-	return uint64( r )
+//formatAttoFIL 把 attoFIL 数值格式化为以 FIL 为单位的字符串
+func formatAttoFIL(atto *big.Int) string {
+	return filecoinTransaction.FormatTokenAmount(filecoinTransaction.BigInt{Int: atto}, filecoinTransaction.FILDecimals)
 }
 
 type AddrBalance struct {
@@ -275,5 +240,7 @@ type AddrBalance struct {
 	RealBalance *decimal.Decimal
 	Balance *big.Int
 	Nonce   uint64
+	Code    string //actor的code CID
 	index   int
+	onChainNonce uint64 //链上nonce，Nonce 为下一笔交易使用的nonce
 }
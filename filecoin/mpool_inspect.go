@@ -0,0 +1,187 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package filecoin
+
+import (
+	"fmt"
+	"math/big"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/tidwall/gjson"
+)
+
+//MpoolMessage 内存池中的一条消息，地址统一为当前网络的前缀，金额单位为FIL，gas价格单位为attoFIL/gas
+type MpoolMessage struct {
+	Cid        string
+	From       string
+	To         string
+	Nonce      uint64
+	Value      string
+	Method     uint64
+	GasLimit   int64
+	GasFeeCap  *big.Int
+	GasPremium *big.Int
+}
+
+//PendingMessage 我方地址在内存池中等待打包的消息
+type PendingMessage struct {
+	*MpoolMessage
+	PendingSince       int64 //开始等待的时间：跟踪中的消息为广播时间，其余为首次检查时在内存池中看到的时间
+	PendingSecs        int64 //已等待的秒数
+	Tracked            bool  //由本钱包广播并跟踪中
+	FeeCapBelowBaseFee bool  //fee cap 低于当前base fee，base fee回落前无法打包
+}
+
+//MpoolInspection 内存池检查结果
+type MpoolInspection struct {
+	Height   uint64   //base fee 所在的高度
+	BaseFee  *big.Int //当前base fee，attoFIL/gas
+	Messages []*PendingMessage
+}
+
+//MpoolInspector 检查我方地址在内存池中的消息，记录消息在检查时首次看到的时间
+type MpoolInspector struct {
+	wm        *WalletManager
+	mu        sync.Mutex
+	firstSeen map[string]int64
+}
+
+//NewMpoolInspector 创建内存池检查器
+func NewMpoolInspector(wm *WalletManager) *MpoolInspector {
+	return &MpoolInspector{
+		wm:        wm,
+		firstSeen: make(map[string]int64),
+	}
+}
+
+//Inspect 返回地址在内存池中的消息，按地址、nonce排序
+func (i *MpoolInspector) Inspect(addresses ...string) (*MpoolInspection, error) {
+	wanted := make(map[string]bool, len(addresses))
+	for _, addr := range addresses {
+		a, err := address.NewFromString(addr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid address %s: %v", addr, err)
+		}
+		wanted[i.wm.formatAddress(a)] = true
+	}
+
+	if err := i.wm.UpdateBaseFeeHistory(); err != nil {
+		return nil, err
+	}
+	latest := i.wm.BaseFeeHistory.Latest()
+	if latest == nil {
+		return nil, fmt.Errorf("base fee history is empty")
+	}
+
+	pending, err := i.wm.GetMpoolPending()
+	if err != nil {
+		return nil, err
+	}
+
+	submitTimes := make(map[string]int64)
+	for _, message := range i.wm.MessageTracker.Pending() {
+		submitTimes[message.Cid] = message.SubmitTime
+	}
+
+	now := time.Now().Unix()
+	inspection := &MpoolInspection{
+		Height:   latest.Height,
+		BaseFee:  latest.BaseFee,
+		Messages: make([]*PendingMessage, 0),
+	}
+
+	i.mu.Lock()
+	seen := make(map[string]int64, len(pending))
+	for _, message := range pending {
+		if first, ok := i.firstSeen[message.Cid]; ok {
+			seen[message.Cid] = first
+		} else {
+			seen[message.Cid] = now
+		}
+
+		if !wanted[message.From] {
+			continue
+		}
+		item := &PendingMessage{
+			MpoolMessage:       message,
+			PendingSince:       seen[message.Cid],
+			FeeCapBelowBaseFee: message.GasFeeCap.Cmp(latest.BaseFee) < 0,
+		}
+		if submitTime, ok := submitTimes[message.Cid]; ok {
+			item.PendingSince = submitTime
+			item.Tracked = true
+		}
+		item.PendingSecs = now - item.PendingSince
+		inspection.Messages = append(inspection.Messages, item)
+	}
+	//已离开内存池的消息不再记录
+	i.firstSeen = seen
+	i.mu.Unlock()
+
+	sort.Slice(inspection.Messages, func(a, b int) bool {
+		if inspection.Messages[a].From != inspection.Messages[b].From {
+			return inspection.Messages[a].From < inspection.Messages[b].From
+		}
+		return inspection.Messages[a].Nonce < inspection.Messages[b].Nonce
+	})
+
+	for _, message := range inspection.Messages {
+		if message.FeeCapBelowBaseFee {
+			i.wm.Log.Warningf("message %s of %s nonce %d is stuck: fee cap %s is below base fee %s",
+				message.Cid, message.From, message.Nonce, message.GasFeeCap, latest.BaseFee)
+		}
+	}
+	return inspection, nil
+}
+
+//parseMpoolMessage 解析 MpoolPending 返回的已签名消息，地址按当前网络的前缀输出
+func (wm *WalletManager) parseMpoolMessage(signedMessage gjson.Result) (*MpoolMessage, error) {
+	message := gjson.Get(signedMessage.Raw, "Message")
+	from, err := address.NewFromString(message.Get("From").String())
+	if err != nil {
+		return nil, fmt.Errorf("invalid from address: %v", err)
+	}
+	to, err := address.NewFromString(message.Get("To").String())
+	if err != nil {
+		return nil, fmt.Errorf("invalid to address: %v", err)
+	}
+	value, ok := new(big.Int).SetString(message.Get("Value").String(), 10)
+	if !ok {
+		return nil, fmt.Errorf("invalid value %q", message.Get("Value").String())
+	}
+	feeCap, ok := new(big.Int).SetString(message.Get("GasFeeCap").String(), 10)
+	if !ok {
+		return nil, fmt.Errorf("invalid gas fee cap %q", message.Get("GasFeeCap").String())
+	}
+	premium, ok := new(big.Int).SetString(message.Get("GasPremium").String(), 10)
+	if !ok {
+		return nil, fmt.Errorf("invalid gas premium %q", message.Get("GasPremium").String())
+	}
+	return &MpoolMessage{
+		Cid:        gjson.Get(signedMessage.Raw, "CID./").String(),
+		From:       wm.formatAddress(from),
+		To:         wm.formatAddress(to),
+		Nonce:      message.Get("Nonce").Uint(),
+		Value:      formatAttoFIL(value),
+		Method:     message.Get("Method").Uint(),
+		GasLimit:   message.Get("GasLimit").Int(),
+		GasFeeCap:  feeCap,
+		GasPremium: premium,
+	}, nil
+}
@@ -0,0 +1,272 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package filecoin
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/blocktree/filecoin-adapter/filecoinTransaction"
+	"github.com/blocktree/openwallet/v2/openwallet"
+)
+
+const (
+	ExtParamRecipients = "recipients" //多接收方交易单每个接收方的广播状态
+)
+
+//recipient 交易单中的一个接收方
+type recipient struct {
+	To     string
+	Amount filecoinTransaction.FIL
+	Method uint64 //按目标actor类型确定的方法号
+	Params []byte
+}
+
+//RecipientStatus 多接收方或多地址出金交易单中单条消息的广播状态
+type RecipientStatus struct {
+	From   string `json:"from"`
+	To     string `json:"to"`
+	Amount string `json:"amount"`
+	Nonce  uint64 `json:"nonce"`
+	TxID   string `json:"txid,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+//RecipientSubmitError 多接收方交易单部分或全部广播失败，Statuses 为每个接收方的状态
+//部分广播成功时，SubmitRawTransaction 同时返回已广播部分的交易
+type RecipientSubmitError struct {
+	Statuses []*RecipientStatus
+}
+
+func (e *RecipientSubmitError) Error() string {
+	failed := make([]string, 0, len(e.Statuses))
+	for _, status := range e.Statuses {
+		if status.Error != "" {
+			failed = append(failed, fmt.Sprintf("%s(nonce %d): %s", status.To, status.Nonce, status.Error))
+		}
+	}
+	return fmt.Sprintf("%d of %d recipients failed: %s", len(failed), len(e.Statuses), strings.Join(failed, "; "))
+}
+
+//parseRecipients 解析交易单的接收方，按地址排序保证每次构建的nonce顺序一致
+func parseRecipients(to map[string]string) ([]*recipient, error) {
+	if len(to) == 0 {
+		return nil, openwallet.Errorf(openwallet.ErrCreateRawTransactionFailed, "receiver is empty")
+	}
+	recipients := make([]*recipient, 0, len(to))
+	for addr, amountStr := range to {
+		amount, err := filecoinTransaction.ParseFIL(amountStr)
+		if err != nil {
+			return nil, openwallet.Errorf(openwallet.ErrCreateRawTransactionFailed, "amount of %s: %v", addr, err)
+		}
+		recipients = append(recipients, &recipient{To: addr, Amount: amount})
+	}
+	sort.Slice(recipients, func(i, j int) bool {
+		return recipients[i].To < recipients[j].To
+	})
+	return recipients, nil
+}
+
+//joinRawMessages 多条消息的 RawHex 为消息JSON数组，单条消息保持原有的JSON对象格式
+func joinRawMessages(rawMessages []string) (string, error) {
+	if len(rawMessages) == 1 {
+		return rawMessages[0], nil
+	}
+	list := make([]json.RawMessage, 0, len(rawMessages))
+	for _, raw := range rawMessages {
+		list = append(list, json.RawMessage(raw))
+	}
+	data, err := json.Marshal(list)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+//splitRawMessages 拆分 RawHex 为单条消息的JSON，顺序与 KeySignature 一致
+func splitRawMessages(rawHex string) ([]string, error) {
+	trimmed := bytes.TrimSpace([]byte(rawHex))
+	if len(trimmed) == 0 || trimmed[0] != '[' {
+		return []string{rawHex}, nil
+	}
+	var list []json.RawMessage
+	if err := json.Unmarshal(trimmed, &list); err != nil {
+		return nil, fmt.Errorf("invalid raw messages: %v", err)
+	}
+	if len(list) == 0 {
+		return nil, fmt.Errorf("raw messages are empty")
+	}
+	rawMessages := make([]string, 0, len(list))
+	for _, raw := range list {
+		rawMessages = append(rawMessages, string(raw))
+	}
+	return rawMessages, nil
+}
+
+//rawMessageSignatures 把 RawHex 中的消息与签名一一对应
+func rawMessageSignatures(rawTx *openwallet.RawTransaction) ([]string, []*openwallet.KeySignature, error) {
+	rawMessages, err := splitRawMessages(rawTx.RawHex)
+	if err != nil {
+		return nil, nil, err
+	}
+	if rawTx.Account == nil {
+		return nil, nil, fmt.Errorf("transaction has no account")
+	}
+	keySignatures := rawTx.Signatures[rawTx.Account.AccountID]
+	if len(keySignatures) != len(rawMessages) {
+		return nil, nil, fmt.Errorf("transaction has %d messages but %d signatures", len(rawMessages), len(keySignatures))
+	}
+	return rawMessages, keySignatures, nil
+}
+
+//submitMultiRecipientTransaction 按nonce顺序逐条广播，某条失败后同一发送地址的后续消息不再广播，避免nonce断档
+//其他发送地址的消息互不影响，多地址出金时每个出金地址各自广播
+//部分失败时返回已广播消息组成的交易与 RecipientSubmitError，全部失败时交易为nil
+func (decoder *TransactionDecoder) submitMultiRecipientTransaction(wrapper openwallet.WalletDAI, rawTx *openwallet.RawTransaction, rawMessages []string, keySignatures []*openwallet.KeySignature) (*openwallet.Transaction, error) {
+	messages := make([]*filecoinTransaction.Message, len(rawMessages))
+	lastNonces := make(map[string]uint64)
+	for i, raw := range rawMessages {
+		message, err := filecoinTransaction.NewMessageFromJSON(raw)
+		if err != nil {
+			return nil, fmt.Errorf("transaction is not wrong message format")
+		}
+		//同一发送地址的消息nonce须连续，多地址出金时每个地址各自连续
+		from := keySignatures[i].Address.Address
+		if last, ok := lastNonces[from]; ok && message.Nonce != last+1 {
+			return nil, fmt.Errorf("messages of %s are not in consecutive nonce order", from)
+		}
+		lastNonces[from] = message.Nonce
+		messages[i] = message
+	}
+
+	//广播结束后释放构建时持有的地址锁
+	defer func() {
+		for i, message := range messages {
+			decoder.wm.AddressLocker.Unlock(keySignatures[i].Address.Address, message.Nonce)
+		}
+	}()
+
+	var (
+		statuses   = make([]*RecipientStatus, len(messages))
+		failed     bool
+		failedFrom = make(map[string]bool)
+	)
+	for i, message := range messages {
+		from := keySignatures[i].Address.Address
+		to := decoder.wm.formatAddress(message.To)
+		//f410发送方的消息接收地址可能已解析为ID地址，状态中使用原接收地址
+		if len(rawTx.TxTo) == len(messages) {
+			to = rawTx.TxTo[i]
+		} else if len(rawTx.TxTo) == 1 {
+			to = rawTx.TxTo[0]
+		}
+		status := &RecipientStatus{
+			From:   from,
+			To:     to,
+			Amount: formatAttoFIL(message.Value.Int),
+			Nonce:  message.Nonce,
+		}
+		statuses[i] = status
+
+		if failedFrom[from] {
+			status.Error = "not submitted, previous message of the sender failed"
+			decoder.wm.NonceManager.Release(from, message.Nonce)
+			continue
+		}
+
+		localCid, err := localMessageCid(rawMessages[i], keySignatures[i].Signature)
+		if err == nil {
+			decoder.wm.MessageTracker.Track(localCid, from, message.Nonce, rawMessages[i], keySignatures[i].Signature)
+			var txid string
+			txid, err = decoder.sendRawMessage(rawMessages[i], keySignatures[i].Signature)
+			if err == nil {
+				status.TxID = txid
+				decoder.submitSucceeded(from, message.Nonce, localCid, txid, rawMessages[i], keySignatures[i].Signature)
+				continue
+			}
+		}
+		status.Error = err.Error()
+		failed = true
+		failedFrom[from] = true
+		decoder.submitFailed(from, message.Nonce, localCid, err)
+	}
+
+	rawTx.SetExtParam(ExtParamRecipients, statuses)
+
+	//已广播的消息，部分失败时交易只包含这些消息
+	var (
+		txFrom   = rawTx.TxFrom
+		txTo     = rawTx.TxTo
+		txAmount = rawTx.TxAmount
+		txID     string
+	)
+	if failed {
+		var amount filecoinTransaction.FIL
+		txFrom, txTo = make([]string, 0), make([]string, 0)
+		seen := make(map[string]bool)
+		for i, status := range statuses {
+			if status.TxID == "" {
+				continue
+			}
+			if from := keySignatures[i].Address.Address; !seen[from] {
+				seen[from] = true
+				txFrom = append(txFrom, from)
+			}
+			txTo = append(txTo, status.To)
+			value, err := filecoinTransaction.NewFILFromBigInt(messages[i].Value)
+			if err == nil {
+				amount = amount.Add(value)
+			}
+		}
+		if len(txTo) == 0 {
+			return nil, &RecipientSubmitError{Statuses: statuses}
+		}
+		txAmount = amount.Unitless()
+	}
+
+	//交易单只有一个TxID，取第一条已广播消息的CID，每条消息的CID见接收方状态
+	for _, status := range statuses {
+		if status.TxID != "" {
+			txID = status.TxID
+			break
+		}
+	}
+	rawTx.TxID = txID
+	rawTx.IsSubmit = true
+
+	tx := openwallet.Transaction{
+		From:       txFrom,
+		To:         txTo,
+		Amount:     txAmount,
+		Coin:       rawTx.Coin,
+		TxID:       rawTx.TxID,
+		Decimal:    decoder.wm.Decimal(),
+		AccountID:  rawTx.Account.AccountID,
+		Fees:       rawTx.Fees,
+		SubmitTime: time.Now().Unix(),
+	}
+	tx.SetExtParam(ExtParamRecipients, statuses)
+	tx.WxID = openwallet.GenTransactionWxID(&tx)
+
+	if failed {
+		return &tx, &RecipientSubmitError{Statuses: statuses}
+	}
+	return &tx, nil
+}
@@ -0,0 +1,307 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package filecoin
+
+import (
+	"math/big"
+	"sort"
+	"strconv"
+
+	"github.com/blocktree/filecoin-adapter/filecoinTransaction"
+	"github.com/blocktree/openwallet/v2/openwallet"
+)
+
+const (
+	ExtParamMultiSource  = "multiSource"  //交易单是否允许多地址出金，未设置时使用配置
+	ExtParamWithdrawPlan = "withdrawPlan" //多地址出金计划
+)
+
+//SourceMessage 多地址出金计划中的一条消息，每条消息由各自的发送地址承担手续费
+type SourceMessage struct {
+	From   string `json:"from"`
+	Nonce  uint64 `json:"nonce"`
+	Amount string `json:"amount"`
+	Fee    string `json:"fee"`
+}
+
+//WithdrawPlan 多地址出金计划，所有消息的金额之和为出金金额
+type WithdrawPlan struct {
+	To      string           `json:"to"`
+	Amount  string           `json:"amount"`
+	Fees    string           `json:"fees"`
+	Sources []*SourceMessage `json:"sources"`
+}
+
+//multiSourceEnabled 交易单的 multiSource 扩展参数优先，未设置时使用配置
+func (decoder *TransactionDecoder) multiSourceEnabled(rawTx *openwallet.RawTransaction) bool {
+	if v := rawTx.GetExtParam().Get(ExtParamMultiSource); v.Exists() {
+		return v.Bool()
+	}
+	return decoder.wm.Config.MultiSourceWithdraw
+}
+
+//accountBalances 获取账户下余额大于0的地址，以及每个地址下一笔交易的nonce
+func (decoder *TransactionDecoder) accountBalances(wrapper openwallet.WalletDAI, accountID string) ([]AddrBalance, error) {
+	addresses, err := wrapper.GetAddressList(0, -1, "AccountID", accountID)
+	if err != nil {
+		return nil, err
+	}
+	if len(addresses) == 0 {
+		return nil, openwallet.Errorf(openwallet.ErrAccountNotAddress, "[%s] have not addresses", accountID)
+	}
+
+	balances := make([]AddrBalance, 0, len(addresses))
+	for i, addr := range addresses {
+		balance, err := decoder.wm.GetAddrBalance(addr.Address)
+		if err != nil {
+			return nil, err
+		}
+		if balance.Balance == nil || balance.Balance.Sign() <= 0 {
+			continue
+		}
+
+		//下一笔交易的nonce只用于选择地址，构建时由 NonceManager 预留
+		balance.onChainNonce, balance.Nonce, err = decoder.wm.NonceManager.Peek(addr.Address)
+		if err != nil {
+			return nil, err
+		}
+		balance.index = i
+		balances = append(balances, *balance)
+	}
+	return balances, nil
+}
+
+//CreateMultiSourceRawTransaction 使用多个地址为一笔出金筹款，返回出金计划
+//交易单的签名与广播与多接收方交易单相同，按组签名后一次提交；各出金地址独立广播，
+//每个地址的结果见 recipients 扩展参数中 from 对应的状态
+func (decoder *TransactionDecoder) CreateMultiSourceRawTransaction(wrapper openwallet.WalletDAI, rawTx *openwallet.RawTransaction) (*WithdrawPlan, error) {
+	recipients, err := parseRecipients(rawTx.To)
+	if err != nil {
+		return nil, err
+	}
+	if len(recipients) != 1 {
+		return nil, openwallet.Errorf(openwallet.ErrCreateRawTransactionFailed, "multi-source withdrawal supports exactly one receiver")
+	}
+	r := recipients[0]
+	r.Method, r.Params, err = decoder.destinationMethod(r.To)
+	if err != nil {
+		return nil, err
+	}
+	tier, err := decoder.rawTxFeeTier(rawTx)
+	if err != nil {
+		return nil, err
+	}
+
+	balances, err := decoder.accountBalances(wrapper, rawTx.Account.AccountID)
+	if err != nil {
+		return nil, err
+	}
+	pending, err := decoder.wm.GetMpoolPendingNonces()
+	if err != nil {
+		return nil, err
+	}
+	candidates, _ := decoder.filterNonceGaps(balances, pending)
+	return decoder.createMultiSourceRawTransaction(wrapper, rawTx, r, candidates, tier, decoder.wm.AddressLocker)
+}
+
+//createMultiSourceRawTransaction 按余额从大到小依次取款，每个地址转出 余额-自身手续费，直到凑够出金金额
+func (decoder *TransactionDecoder) createMultiSourceRawTransaction(wrapper openwallet.WalletDAI, rawTx *openwallet.RawTransaction, r *recipient, candidates []AddrBalance, tier *FeeTier, locker senderLocker) (plan *WithdrawPlan, err error) {
+	sort.Slice(candidates, func(i int, j int) bool {
+		return candidates[i].Balance.Cmp(candidates[j].Balance) > 0
+	})
+
+	var (
+		remaining   = r.Amount.AttoFIL()
+		rawMessages = make([]string, 0)
+		keySigs     = make([]*openwallet.KeySignature, 0)
+		froms       = make([]string, 0)
+		nonceMap    = make(map[string]uint64)
+		totalFee    = new(big.Int)
+		gasFeeCap   = new(big.Int)
+		accountSum  = new(big.Int)
+		reserved    = make(map[string]uint64)
+	)
+	plan = &WithdrawPlan{To: r.To, Amount: r.Amount.Unitless()}
+
+	//先选出出金地址，再按地址顺序统一加锁，避免与其他构建交叉等待
+	type source struct {
+		balance   AddrBalance
+		msgTo     string
+		msgMethod uint64
+		msgParams []byte
+		feeInfo   *txFeeInfo
+		take      *big.Int
+	}
+	sources := make([]*source, 0)
+	for _, c := range candidates {
+		accountSum.Add(accountSum, c.Balance)
+		if remaining.Sign() <= 0 {
+			continue
+		}
+
+		msgTo, msgMethod, msgParams, err := decoder.messageTarget(c.Address, r.To, r.Method, r.Params)
+		if err != nil {
+			decoder.wm.Log.Warningf("skip source %s: %v", c.Address, err)
+			continue
+		}
+
+		//转出金额取决于手续费：先按0金额估算得到可转出金额，再按实际金额重新估算
+		feeInfo, estimateErr := decoder.wm.GetTransactionFeeEstimatedWithTier(c.Address, msgTo, big.NewInt(0), c.Nonce, msgMethod, msgParams, tier)
+		if estimateErr != nil {
+			decoder.wm.Log.Warningf("skip source %s, estimate fee failed: %v", c.Address, estimateErr)
+			continue
+		}
+		take := sourceTake(c.Balance, feeInfo.Fee, remaining)
+		if take.Sign() <= 0 {
+			continue
+		}
+
+		feeInfo, estimateErr = decoder.wm.GetTransactionFeeEstimatedWithTier(c.Address, msgTo, take, c.Nonce, msgMethod, msgParams, tier)
+		if estimateErr != nil {
+			decoder.wm.Log.Warningf("skip source %s, estimate fee of %s failed: %v", c.Address, formatAttoFIL(take), estimateErr)
+			continue
+		}
+		//按实际金额估算的手续费更高时，减少转出金额
+		take = sourceTake(c.Balance, feeInfo.Fee, take)
+		if take.Sign() <= 0 {
+			continue
+		}
+		sources = append(sources, &source{balance: c, msgTo: msgTo, msgMethod: msgMethod, msgParams: msgParams, feeInfo: feeInfo, take: take})
+		remaining.Sub(remaining, take)
+	}
+
+	if remaining.Sign() > 0 || len(sources) == 0 {
+		return nil, openwallet.Errorf(openwallet.ErrInsufficientBalanceOfAccount, "the account balance: %s is not enough for %s plus fees", formatAttoFIL(accountSum), r.Amount.Unitless())
+	}
+
+	addresses := make([]string, 0, len(sources))
+	for _, s := range sources {
+		addresses = append(addresses, s.balance.Address)
+	}
+	lease, err := locker.Lock(decoder.wm.AddressLocker.Timeout(), addresses...)
+	if err != nil {
+		return nil, err
+	}
+
+	//构建失败时释放已预留的nonce与地址锁
+	defer func() {
+		if err != nil {
+			for addr, nonce := range reserved {
+				decoder.wm.NonceManager.Release(addr, nonce)
+			}
+			lease.Unlock()
+			return
+		}
+		lease.Hold(singleNonces(nonceMap))
+	}()
+
+	for _, s := range sources {
+		c := s.balance
+		nonce, err := decoder.wm.NonceManager.Reserve(c.Address, 1)
+		if err != nil {
+			return nil, err
+		}
+		reserved[c.Address] = nonce
+		//预留的nonce与估算时不同，按预留的nonce重新估算
+		if nonce != c.Nonce {
+			s.feeInfo, err = decoder.wm.GetTransactionFeeEstimatedWithTier(c.Address, s.msgTo, s.take, nonce, s.msgMethod, s.msgParams, tier)
+			if err != nil {
+				return nil, err
+			}
+		}
+		c.Nonce = nonce
+		amount, err := filecoinTransaction.NewFIL(s.take)
+		if err != nil {
+			return nil, err
+		}
+
+		//出金地址在加锁前选出，加锁后按可用余额重新确认
+		spend := new(big.Int).Add(s.take, s.feeInfo.Fee)
+		spendable, err := decoder.spendableBalance(c.Address, nonce)
+		if err != nil {
+			return nil, err
+		}
+		if spendable.Cmp(spend) < 0 {
+			return nil, openwallet.Errorf(openwallet.ErrInsufficientBalanceOfAccount, "the spendable balance of %s: %s is not enough for %s with fee %s", c.Address, formatAttoFIL(spendable), amount.Unitless(), formatAttoFIL(s.feeInfo.Fee))
+		}
+
+		emptyTrans, message, err := decoder.createEmptyRawTransactionAndMessageWithMethod(c.Address, s.msgTo, amount.Unitless(), c.Nonce, s.feeInfo, s.msgMethod, s.msgParams)
+		if err != nil {
+			return nil, err
+		}
+		addr, err := wrapper.GetAddress(c.Address)
+		if err != nil {
+			return nil, err
+		}
+
+		rawMessages = append(rawMessages, emptyTrans)
+		keySigs = append(keySigs, &openwallet.KeySignature{
+			EccType: decoder.wm.Config.CurveType,
+			Nonce:   "0x" + strconv.FormatUint(c.Nonce, 16),
+			Address: addr,
+			Message: message,
+			RSV:     true,
+		})
+		froms = append(froms, c.Address)
+		nonceMap[c.Address] = c.Nonce
+		decoder.wm.NonceManager.SetSpend(c.Address, c.Nonce, spend)
+		totalFee.Add(totalFee, s.feeInfo.Fee)
+		gasFeeCap = maxBigInt(gasFeeCap, s.feeInfo.GasFeeCap)
+		plan.Sources = append(plan.Sources, &SourceMessage{
+			From:   c.Address,
+			Nonce:  c.Nonce,
+			Amount: amount.Unitless(),
+			Fee:    formatAttoFIL(s.feeInfo.Fee),
+		})
+	}
+
+	rawHex, err := joinRawMessages(rawMessages)
+	if err != nil {
+		return nil, err
+	}
+	plan.Fees = formatAttoFIL(totalFee)
+
+	rawTx.TxFrom = froms
+	rawTx.TxTo = []string{r.To}
+	rawTx.SetExtParam("nonce", nonceMap)
+	rawTx.SetExtParam(ExtParamWithdrawPlan, plan)
+	rawTx.SetExtParam(ExtParamFeeTier, tier.Name)
+	rawTx.TxAmount = r.Amount.Unitless()
+	rawTx.Fees = plan.Fees
+	rawTx.RawHex = rawHex
+
+	if rawTx.Signatures == nil {
+		rawTx.Signatures = make(map[string][]*openwallet.KeySignature)
+	}
+	rawTx.Signatures[rawTx.Account.AccountID] = keySigs
+
+	//FeeRate 为每gas的手续费上限，单位FIL，多条消息时取最大的 fee cap
+	rawTx.FeeRate = formatAttoFIL(gasFeeCap)
+
+	rawTx.IsBuilt = true
+
+	decoder.wm.Log.Infof("multi-source withdrawal of %s to %s uses %d addresses, fees %s", plan.Amount, plan.To, len(plan.Sources), plan.Fees)
+	return plan, nil
+}
+
+//sourceTake 出金地址可转出的金额：余额扣除手续费，且不超过还需筹集的金额
+func sourceTake(balance, fee, remaining *big.Int) *big.Int {
+	available := new(big.Int).Sub(balance, fee)
+	if available.Cmp(remaining) > 0 {
+		return new(big.Int).Set(remaining)
+	}
+	return available
+}
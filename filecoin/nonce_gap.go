@@ -0,0 +1,296 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package filecoin
+
+import (
+	"sort"
+
+	"github.com/blocktree/openwallet/v2/openwallet"
+	"github.com/filecoin-project/specs-actors/actors/builtin"
+)
+
+//NonceGapReport 一个地址的nonce断档情况
+type NonceGapReport struct {
+	Address      string
+	OnChainNonce uint64   //链上nonce
+	MpoolNonce   uint64   //MpoolGetNonce，包含内存池中的消息
+	Pending      []uint64 //内存池中的nonce
+	Tracked      []uint64 //本地跟踪中、不在内存池的nonce，由重新广播处理
+	Reserved     []uint64 //正在构建、尚未广播的nonce
+	Missing      []uint64 //缺失的nonce，需要补齐
+	Blocked      bool     //缺失的nonce之后有待打包消息，这些消息无法上链
+
+	//补齐结果，按 Missing 顺序
+	Fills []*NonceGapFill `json:",omitempty"`
+}
+
+//NonceGapFill 补齐一个缺失nonce的自转账消息
+type NonceGapFill struct {
+	Nonce uint64
+	TxID  string
+	Error string `json:",omitempty"`
+}
+
+//DetectNonceGaps 对比链上nonce、MpoolGetNonce、内存池与本地记录，找出各地址缺失的nonce
+func (wm *WalletManager) DetectNonceGaps(addresses []string) ([]*NonceGapReport, error) {
+	mpool, err := wm.GetMpoolPendingNonces()
+	if err != nil {
+		return nil, err
+	}
+
+	tracked := make(map[string][]uint64)
+	for _, message := range wm.MessageTracker.Pending() {
+		tracked[message.From] = append(tracked[message.From], message.Nonce)
+	}
+
+	reports := make([]*NonceGapReport, 0, len(addresses))
+	for _, address := range addresses {
+		onChain, err := wm.GetAddrOnChainNonce(address)
+		if err != nil {
+			return nil, err
+		}
+		mpoolNonce, err := wm.GetMpoolGetNonce(address)
+		if err != nil {
+			return nil, err
+		}
+		reserved := make([]uint64, 0)
+		for _, r := range wm.NonceManager.Reservations(address) {
+			if r.Unsubmitted() {
+				reserved = append(reserved, r.Nonce)
+			}
+		}
+
+		report := nonceGapReport(address, onChain, mpoolNonce, pendingNonces(mpool, address), tracked[address], reserved)
+		if report.Blocked {
+			wm.Log.Warningf("%s is blocked by nonce gap: on chain %d, missing %v, pending %v", address, onChain, report.Missing, report.Pending)
+		}
+		reports = append(reports, report)
+	}
+	return reports, nil
+}
+
+//nonceGapReport 链上nonce到已知最大nonce之间，不在内存池、不在跟踪中、也未被预留的nonce为缺失
+func nonceGapReport(address string, onChain, mpoolNonce uint64, pending, tracked, reserved []uint64) *NonceGapReport {
+	report := &NonceGapReport{
+		Address:      address,
+		OnChainNonce: onChain,
+		MpoolNonce:   mpoolNonce,
+		Pending:      noncesFrom(pending, onChain),
+		Reserved:     noncesFrom(reserved, onChain),
+		Missing:      make([]uint64, 0),
+	}
+
+	known := make(map[uint64]bool)
+	for _, n := range report.Pending {
+		known[n] = true
+	}
+	report.Tracked = make([]uint64, 0)
+	for _, n := range noncesFrom(tracked, onChain) {
+		if !known[n] {
+			report.Tracked = append(report.Tracked, n)
+		}
+	}
+
+	//已广播的nonce中最大的，决定需要检查的范围；预留的nonce尚未广播，不扩大范围
+	var highest uint64
+	hasBroadcast := false
+	for _, list := range [][]uint64{report.Pending, report.Tracked} {
+		for _, n := range list {
+			if !hasBroadcast || n > highest {
+				highest = n
+				hasBroadcast = true
+			}
+		}
+	}
+	if !hasBroadcast {
+		return report
+	}
+
+	skip := make(map[uint64]bool)
+	for _, list := range [][]uint64{report.Pending, report.Tracked, report.Reserved} {
+		for _, n := range list {
+			skip[n] = true
+		}
+	}
+	for n := onChain; n < highest; n++ {
+		if !skip[n] {
+			report.Missing = append(report.Missing, n)
+		}
+	}
+	report.Blocked = len(report.Missing) > 0
+	return report
+}
+
+//noncesFrom 去掉已上链的nonce，去重并升序
+func noncesFrom(nonces []uint64, onChain uint64) []uint64 {
+	seen := make(map[uint64]bool)
+	result := make([]uint64, 0, len(nonces))
+	for _, n := range nonces {
+		if n >= onChain && !seen[n] {
+			seen[n] = true
+			result = append(result, n)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i] < result[j]
+	})
+	return result
+}
+
+//CheckNonceGaps 检查账户下所有地址的nonce断档，fill 为true时用给自己转0金额的消息补齐缺失的nonce
+//补齐消息与普通交易单一样构建、签名、验证、广播，签名需要钱包已解锁
+func (decoder *TransactionDecoder) CheckNonceGaps(wrapper openwallet.WalletDAI, accountID string, fill bool) ([]*NonceGapReport, error) {
+	addresses, err := wrapper.GetAddressList(0, -1, "AccountID", accountID)
+	if err != nil {
+		return nil, err
+	}
+	list := make([]string, 0, len(addresses))
+	for _, addr := range addresses {
+		list = append(list, addr.Address)
+	}
+
+	reports, err := decoder.wm.DetectNonceGaps(list)
+	if err != nil {
+		return nil, err
+	}
+	if !fill {
+		return reports, nil
+	}
+
+	account, err := wrapper.GetAssetsAccountInfo(accountID)
+	if err != nil {
+		return nil, err
+	}
+	for _, report := range reports {
+		if len(report.Missing) == 0 {
+			continue
+		}
+		rawTxs, err := decoder.CreateNonceGapRawTransactions(wrapper, account, report)
+		if err != nil {
+			decoder.wm.Log.Errorf("create nonce gap fill of %s failed: %v", report.Address, err)
+			for _, n := range report.Missing {
+				report.Fills = append(report.Fills, &NonceGapFill{Nonce: n, Error: err.Error()})
+			}
+			continue
+		}
+		for i, rawTx := range rawTxs {
+			report.Fills = append(report.Fills, decoder.submitNonceGapFill(wrapper, rawTx, report.Missing[i]))
+		}
+	}
+	return reports, nil
+}
+
+//CreateNonceGapRawTransactions 为每个缺失的nonce创建给自己转0金额的交易单，按nonce升序
+//加锁后重新检查，检测之后已被预留或已在跟踪中的nonce从 report.Missing 中去掉，交易单与剩余的 Missing 一一对应
+//交易单需继续走 SignRawTransaction/VerifyRawTransaction/SubmitRawTransaction
+func (decoder *TransactionDecoder) CreateNonceGapRawTransactions(wrapper openwallet.WalletDAI, account *openwallet.AssetsAccount, report *NonceGapReport) (rawTxs []*openwallet.RawTransaction, err error) {
+	if len(report.Missing) == 0 {
+		return nil, nil
+	}
+	from := report.Address
+
+	tier, err := decoder.wm.GetFeeTier("")
+	if err != nil {
+		return nil, err
+	}
+
+	lease, err := decoder.wm.AddressLocker.Lock(decoder.wm.AddressLocker.Timeout(), from)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err != nil {
+			lease.Unlock()
+			return
+		}
+		lease.Hold(map[string][]uint64{from: report.Missing})
+	}()
+
+	report.Missing = decoder.wm.unclaimedNonces(from, report.Missing)
+	if len(report.Missing) == 0 {
+		return nil, nil
+	}
+
+	coin := openwallet.Coin{Symbol: decoder.wm.Symbol()}
+	for _, nonce := range report.Missing {
+		feeInfo, err := decoder.wm.GetTransactionFeeEstimatedWithTier(from, from, nil, nonce, uint64(builtin.MethodSend), nil, tier)
+		if err != nil {
+			return nil, err
+		}
+		rawTx := &openwallet.RawTransaction{
+			Coin:    coin,
+			Account: account,
+			To:      map[string]string{from: "0"},
+		}
+		if err := decoder.createRawTransactionWithMethod(wrapper, rawTx, from, feeInfo, nonce, uint64(builtin.MethodSend), nil); err != nil {
+			return nil, err
+		}
+		rawTx.SetExtParam(ExtParamFeeTier, tier.Name)
+		rawTxs = append(rawTxs, rawTx)
+	}
+	decoder.wm.Log.Infof("created %d nonce gap fills of %s: %v", len(rawTxs), from, report.Missing)
+	return rawTxs, nil
+}
+
+//unclaimedNonces 去掉已被 NonceManager 预留或已在 MessageTracker 跟踪中的nonce
+func (wm *WalletManager) unclaimedNonces(address string, nonces []uint64) []uint64 {
+	claimed := make(map[uint64]bool)
+	for _, r := range wm.NonceManager.Reservations(address) {
+		if r.Unsubmitted() {
+			claimed[r.Nonce] = true
+		}
+	}
+	for _, message := range wm.MessageTracker.Pending() {
+		if message.From == address {
+			claimed[message.Nonce] = true
+		}
+	}
+
+	result := make([]uint64, 0, len(nonces))
+	for _, n := range nonces {
+		if claimed[n] {
+			wm.Log.Warningf("nonce %d of %s is claimed after nonce gap detection, skip", n, address)
+			continue
+		}
+		result = append(result, n)
+	}
+	return result
+}
+
+//submitNonceGapFill 签名、验证并广播一条补齐消息
+func (decoder *TransactionDecoder) submitNonceGapFill(wrapper openwallet.WalletDAI, rawTx *openwallet.RawTransaction, nonce uint64) *NonceGapFill {
+	result := &NonceGapFill{Nonce: nonce}
+	if err := decoder.SignRawTransaction(wrapper, rawTx); err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	if err := decoder.VerifyRawTransaction(wrapper, rawTx); err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	if !rawTx.IsCompleted {
+		result.Error = "transaction verify failed"
+		return result
+	}
+	tx, err := decoder.SubmitRawTransaction(wrapper, rawTx)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	result.TxID = tx.TxID
+	decoder.wm.Log.Infof("nonce gap of %s at %d filled by %s", rawTx.TxFrom, nonce, tx.TxID)
+	return result
+}
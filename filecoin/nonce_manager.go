@@ -0,0 +1,395 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package filecoin
+
+import (
+	"fmt"
+	"math/big"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/asdine/storm"
+)
+
+const (
+	nonceDBFile = "nonce.db" //nonce预留数据库文件
+
+	NonceStatusReserved  = "reserved"  //构建交易单时预留，尚未广播
+	NonceStatusBundled   = "bundled"   //已打入签名包，等待离线签名后广播，不会过期，直到 SubmitSignBundle 或 CancelSignBundle
+	NonceStatusConfirmed = "confirmed" //广播成功
+	NonceStatusRetired   = "retired"   //广播结果未知，消息可能已进入内存池，不再分配
+)
+
+//NonceReservation 一个地址已分配的nonce
+type NonceReservation struct {
+	Key       string `storm:"id"` //address_nonce
+	Address   string `storm:"index"`
+	Nonce     uint64
+	Status    string
+	TxID      string
+	Spend     string //消息的金额与手续费上限，attoFIL，构建成功后记录
+	UpdatedAt int64
+}
+
+//Unsubmitted 已预留但尚未广播，包括签名包中的nonce
+func (r *NonceReservation) Unsubmitted() bool {
+	return r.Status == NonceStatusReserved || r.Status == NonceStatusBundled
+}
+
+//NonceManager 分配与跟踪发送地址的nonce，预留记录持久化到本地数据库
+//构建交易单时 Reserve，广播成功 Confirm，失败时 Release（可重新分配）或 Retire（不再分配）
+//每次分配前与链上nonce、MpoolGetNonce 对账：链上已使用的记录删除，超过 NonceDiff 秒仍未进入内存池的记录视为丢弃（签名包中的除外）
+type NonceManager struct {
+	wm           *WalletManager
+	mu           sync.Mutex
+	dbMu         sync.Mutex
+	db           *storm.DB
+	reservations map[string]map[uint64]*NonceReservation
+}
+
+//NewNonceManager 创建nonce管理器
+func NewNonceManager(wm *WalletManager) *NonceManager {
+	return &NonceManager{
+		wm:           wm,
+		reservations: make(map[string]map[uint64]*NonceReservation),
+	}
+}
+
+//Reserve 为地址预留 count 个连续nonce，返回第一个；从可分配的起始nonce开始取最小的一段连续空闲nonce
+//并发调用不会得到相同的nonce
+func (m *NonceManager) Reserve(address string, count int) (uint64, error) {
+	if count <= 0 {
+		return 0, fmt.Errorf("nonce count must be positive")
+	}
+
+	onChain, mpoolNonce, err := m.chainNonces(address)
+	if err != nil {
+		return 0, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	base := m.reconcile(address, onChain, mpoolNonce)
+	entries := m.entries(address)
+	first := freeRun(entries, base, count)
+
+	now := time.Now().Unix()
+	for n := first; n < first+uint64(count); n++ {
+		r := &NonceReservation{
+			Key:       nonceKey(address, n),
+			Address:   address,
+			Nonce:     n,
+			Status:    NonceStatusReserved,
+			UpdatedAt: now,
+		}
+		entries[n] = r
+		m.save(r)
+	}
+	m.wm.Log.Infof("%s reserved nonce %d-%d", address, first, first+uint64(count)-1)
+	return first, nil
+}
+
+//Peek 返回链上nonce与已广播消息之后的下一个nonce，不预留，尚未广播的预留不计入
+func (m *NonceManager) Peek(address string) (onChain uint64, next uint64, err error) {
+	onChain, mpoolNonce, err := m.chainNonces(address)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	next = m.reconcile(address, onChain, mpoolNonce)
+	for n, r := range m.entries(address) {
+		if !r.Unsubmitted() && n >= next {
+			next = n + 1
+		}
+	}
+	return onChain, next, nil
+}
+
+//SetSpend 记录预留nonce的消息将花费的金额与手续费上限，尚未广播时计入 ReservedSpend
+func (m *NonceManager) SetSpend(address string, nonce uint64, spend *big.Int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	r, ok := m.entries(address)[nonce]
+	if !ok {
+		return
+	}
+	r.Spend = spend.String()
+	m.save(r)
+}
+
+//ReservedSpend 地址已构建、尚未广播的消息将花费的金额之和，exclude 中的nonce不计入
+func (m *NonceManager) ReservedSpend(address string, exclude ...uint64) *big.Int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	skip := make(map[uint64]bool, len(exclude))
+	for _, n := range exclude {
+		skip[n] = true
+	}
+	total := new(big.Int)
+	for n, r := range m.entries(address) {
+		if !r.Unsubmitted() || skip[n] || r.Spend == "" {
+			continue
+		}
+		if spend, ok := new(big.Int).SetString(r.Spend, 10); ok {
+			total.Add(total, spend)
+		}
+	}
+	return total
+}
+
+//Bundle 预留的nonce已打入签名包，离线签名耗时不确定，不再按 NonceDiff 过期
+func (m *NonceManager) Bundle(address string, nonces ...uint64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	entries := m.entries(address)
+	for _, n := range nonces {
+		r, ok := entries[n]
+		if !ok || r.Status != NonceStatusReserved {
+			continue
+		}
+		r.Status = NonceStatusBundled
+		r.UpdatedAt = time.Now().Unix()
+		m.save(r)
+	}
+}
+
+//Unbundle 签名包取消或其中的交易未能广播，释放仍在签名包中的nonce，已广播的不受影响
+func (m *NonceManager) Unbundle(address string, nonces ...uint64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	entries := m.entries(address)
+	for _, n := range nonces {
+		r, ok := entries[n]
+		if !ok || r.Status != NonceStatusBundled {
+			continue
+		}
+		delete(entries, n)
+		m.remove(r)
+		m.wm.Log.Infof("%s released bundled nonce %d", address, n)
+	}
+}
+
+//Confirm 消息广播成功
+func (m *NonceManager) Confirm(address string, nonce uint64, txid string) {
+	m.setStatus(address, nonce, NonceStatusConfirmed, txid)
+}
+
+//Retire 广播结果未知，nonce不再分配，直到链上nonce超过它或超时
+func (m *NonceManager) Retire(address string, nonce uint64, txid string) {
+	m.setStatus(address, nonce, NonceStatusRetired, txid)
+}
+
+//Release 消息未广播或被节点拒绝，nonce可重新分配
+func (m *NonceManager) Release(address string, nonces ...uint64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	entries := m.entries(address)
+	for _, n := range nonces {
+		r, ok := entries[n]
+		if !ok {
+			continue
+		}
+		delete(entries, n)
+		m.remove(r)
+		m.wm.Log.Infof("%s released nonce %d", address, n)
+	}
+}
+
+//Reservations 地址当前的nonce记录，按nonce升序
+func (m *NonceManager) Reservations(address string) []*NonceReservation {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	list := make([]*NonceReservation, 0)
+	for _, r := range m.entries(address) {
+		copied := *r
+		list = append(list, &copied)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Nonce < list[j].Nonce
+	})
+	return list
+}
+
+func (m *NonceManager) setStatus(address string, nonce uint64, status, txid string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	entries := m.entries(address)
+	r, ok := entries[nonce]
+	if !ok {
+		//未经预留构建的交易单（如离线构建），同样记录，避免再被分配
+		r = &NonceReservation{Key: nonceKey(address, nonce), Address: address, Nonce: nonce}
+		entries[nonce] = r
+	}
+	r.Status = status
+	r.TxID = txid
+	r.UpdatedAt = time.Now().Unix()
+	m.save(r)
+	m.wm.Log.Infof("%s nonce %d %s, txid: %s", address, nonce, status, txid)
+}
+
+//LastSubmitTime 地址最近一次广播消息的时间，消息上链后记录被清理，没有记录时返回0
+func (m *NonceManager) LastSubmitTime(address string) int64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var last int64
+	for _, r := range m.entries(address) {
+		if !r.Unsubmitted() && r.UpdatedAt > last {
+			last = r.UpdatedAt
+		}
+	}
+	return last
+}
+
+//chainNonces 查询链上nonce与 MpoolGetNonce，在持有 m.mu 之前调用，避免RPC阻塞其他地址的分配
+func (m *NonceManager) chainNonces(address string) (onChain uint64, mpoolNonce uint64, err error) {
+	onChain, err = m.wm.GetAddrOnChainNonce(address)
+	if err != nil {
+		return 0, 0, err
+	}
+	mpoolNonce, err = m.wm.GetMpoolGetNonce(address)
+	if err != nil {
+		return 0, 0, err
+	}
+	return onChain, mpoolNonce, nil
+}
+
+//reconcile 与链上nonce、内存池对账，返回可分配的起始nonce（两者的较大值），调用方持有 m.mu
+func (m *NonceManager) reconcile(address string, onChain, mpoolNonce uint64) uint64 {
+	base := onChain
+	if mpoolNonce > base {
+		base = mpoolNonce
+	}
+
+	expire := time.Now().Unix() - int64(m.wm.Config.NonceDiff)
+	entries := m.entries(address)
+	for n, r := range entries {
+		switch {
+		case n < onChain:
+			//已上链
+		case r.Status == NonceStatusBundled:
+			//签名包中的nonce等待离线签名，不过期
+			continue
+		case r.UpdatedAt < expire && (r.Status == NonceStatusReserved || n >= mpoolNonce):
+			//预留后长时间未广播，或广播后未进入内存池
+			m.wm.Log.Warningf("%s nonce %d (%s, txid: %s) expired", address, n, r.Status, r.TxID)
+		default:
+			continue
+		}
+		delete(entries, n)
+		m.remove(r)
+	}
+	return base
+}
+
+//entries 地址的nonce记录，首次访问时从数据库加载
+func (m *NonceManager) entries(address string) map[uint64]*NonceReservation {
+	entries, ok := m.reservations[address]
+	if ok {
+		return entries
+	}
+	entries = make(map[uint64]*NonceReservation)
+	if db, err := m.openDB(); err == nil {
+		var list []*NonceReservation
+		if err := db.Find("Address", address, &list); err != nil && err != storm.ErrNotFound {
+			m.wm.Log.Std.Error("load nonce reservations of %s failed, err=%v", address, err)
+		}
+		for _, r := range list {
+			entries[r.Nonce] = r
+		}
+	}
+	m.reservations[address] = entries
+	return entries
+}
+
+//openDB 打开nonce数据库，只打开一次并复用；未配置数据目录时只在内存中记录
+func (m *NonceManager) openDB() (*storm.DB, error) {
+	m.dbMu.Lock()
+	defer m.dbMu.Unlock()
+
+	if m.db != nil {
+		return m.db, nil
+	}
+	if len(m.wm.Config.DBPath) == 0 {
+		return nil, fmt.Errorf("nonce db path is not setup")
+	}
+	db, err := storm.Open(filepath.Join(m.wm.Config.DBPath, nonceDBFile))
+	if err != nil {
+		return nil, err
+	}
+	m.db = db
+	return m.db, nil
+}
+
+func (m *NonceManager) save(r *NonceReservation) {
+	db, err := m.openDB()
+	if err != nil {
+		return
+	}
+	if err := db.Save(r); err != nil {
+		m.wm.Log.Std.Error("save nonce %s failed, err=%v", r.Key, err)
+	}
+}
+
+func (m *NonceManager) remove(r *NonceReservation) {
+	db, err := m.openDB()
+	if err != nil {
+		return
+	}
+	if err := db.DeleteStruct(r); err != nil && err != storm.ErrNotFound {
+		m.wm.Log.Std.Error("delete nonce %s failed, err=%v", r.Key, err)
+	}
+}
+
+func nonceKey(address string, nonce uint64) string {
+	return address + "_" + strconv.FormatUint(nonce, 10)
+}
+
+//freeRun 从base开始，第一段长度为count、都未被记录的连续nonce的起始值
+func freeRun(entries map[uint64]*NonceReservation, base uint64, count int) uint64 {
+	first, run := base, 0
+	for n := base; run < count; n++ {
+		if _, used := entries[n]; used {
+			first, run = n+1, 0
+			continue
+		}
+		run++
+	}
+	return first
+}
+
+//nonceRange 从first开始的count个连续nonce
+func nonceRange(first uint64, count int) []uint64 {
+	nonces := make([]uint64, count)
+	for i := range nonces {
+		nonces[i] = first + uint64(i)
+	}
+	return nonces
+}
@@ -0,0 +1,235 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package filecoin
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/blocktree/filecoin-adapter/filecoin_rpc"
+)
+
+const testNonceAddress = "f1abjxfbp274xpdqcpuaykwkfb43omjotacm2p3za"
+
+//testNonceNode 只应答 StateGetActor 与 MpoolGetNonce 的节点
+func testNonceNode(onChain, mpoolNonce uint64) (*WalletManager, *httptest.Server) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Method string `json:"method"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		switch body.Method {
+		case "Filecoin.StateGetActor":
+			fmt.Fprintf(w, `{"jsonrpc":"2.0","id":1,"result":{"Balance":"0","Nonce":%d}}`, onChain)
+		case "Filecoin.MpoolGetNonce":
+			fmt.Fprintf(w, `{"jsonrpc":"2.0","id":1,"result":%d}`, mpoolNonce)
+		default:
+			fmt.Fprintf(w, `{"jsonrpc":"2.0","id":1,"error":{"code":1,"message":"unexpected method %s"}}`, body.Method)
+		}
+	}))
+
+	wm := NewWalletManager()
+	wm.WalletClient = &filecoin_rpc.Client{BaseURL: srv.URL}
+	wm.Config.NonceDiff = 3600
+	return wm, srv
+}
+
+func TestNonceManager_ConcurrentReserve(t *testing.T) {
+	wm, srv := testNonceNode(10, 12)
+	defer srv.Close()
+
+	var (
+		wg     sync.WaitGroup
+		mu     sync.Mutex
+		nonces = make(map[uint64]int)
+		total  int
+	)
+	for i := 0; i < 20; i++ {
+		count := i%3 + 1
+		total += count
+		wg.Add(1)
+		go func(count int) {
+			defer wg.Done()
+			first, err := wm.NonceManager.Reserve(testNonceAddress, count)
+			if err != nil {
+				t.Errorf("Reserve failed: %v", err)
+				return
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			for _, n := range nonceRange(first, count) {
+				nonces[n]++
+			}
+		}(count)
+	}
+	wg.Wait()
+
+	//全部预留从MpoolGetNonce开始连续且不重复
+	if len(nonces) != total {
+		t.Fatalf("reserved %d distinct nonces, want %d", len(nonces), total)
+	}
+	for n := uint64(12); n < 12+uint64(total); n++ {
+		if nonces[n] != 1 {
+			t.Errorf("nonce %d reserved %d times", n, nonces[n])
+		}
+	}
+}
+
+func TestNonceManager_ReleaseRetire(t *testing.T) {
+	wm, srv := testNonceNode(5, 5)
+	defer srv.Close()
+	m := wm.NonceManager
+
+	first, err := m.Reserve(testNonceAddress, 3)
+	if err != nil || first != 5 {
+		t.Fatalf("Reserve = %d, %v; want 5", first, err)
+	}
+
+	//释放的nonce可重新分配，结果未知的nonce不再分配
+	m.Release(testNonceAddress, 5)
+	m.Retire(testNonceAddress, 6, "bafy-retired")
+	m.Confirm(testNonceAddress, 7, "bafy-confirmed")
+
+	next, err := m.Reserve(testNonceAddress, 1)
+	if err != nil || next != 5 {
+		t.Fatalf("Reserve after release = %d, %v; want 5", next, err)
+	}
+	next, err = m.Reserve(testNonceAddress, 2)
+	if err != nil || next != 8 {
+		t.Fatalf("Reserve 2 = %d, %v; want 8", next, err)
+	}
+
+	if _, peek, err := m.Peek(testNonceAddress); err != nil || peek != 8 {
+		t.Errorf("Peek = %d, %v; want 8", peek, err)
+	}
+}
+
+func TestNonceManager_Reconcile(t *testing.T) {
+	now := time.Now().Unix()
+	old := now - 7200
+
+	tests := []struct {
+		name       string
+		entries    []*NonceReservation
+		onChain    uint64
+		mpoolNonce uint64
+		base       uint64
+		remain     []uint64
+	}{
+		{
+			name:       "on chain nonces are dropped",
+			entries:    []*NonceReservation{{Nonce: 3, Status: NonceStatusConfirmed, UpdatedAt: now}, {Nonce: 5, Status: NonceStatusConfirmed, UpdatedAt: now}},
+			onChain:    5,
+			mpoolNonce: 6,
+			base:       6,
+			remain:     []uint64{5},
+		},
+		{
+			name:       "expired reservation is dropped",
+			entries:    []*NonceReservation{{Nonce: 5, Status: NonceStatusReserved, UpdatedAt: old}, {Nonce: 6, Status: NonceStatusReserved, UpdatedAt: now}},
+			onChain:    5,
+			mpoolNonce: 5,
+			base:       5,
+			remain:     []uint64{6},
+		},
+		{
+			name:       "expired broadcast missing from mpool is dropped",
+			entries:    []*NonceReservation{{Nonce: 5, Status: NonceStatusRetired, UpdatedAt: old}, {Nonce: 6, Status: NonceStatusConfirmed, UpdatedAt: old}},
+			onChain:    5,
+			mpoolNonce: 6,
+			base:       6,
+			remain:     []uint64{5},
+		},
+		{
+			name:       "bundled nonce never expires",
+			entries:    []*NonceReservation{{Nonce: 5, Status: NonceStatusBundled, UpdatedAt: old}},
+			onChain:    5,
+			mpoolNonce: 5,
+			base:       5,
+			remain:     []uint64{5},
+		},
+		{
+			name:       "mpool nonce behind chain",
+			entries:    []*NonceReservation{{Nonce: 9, Status: NonceStatusReserved, UpdatedAt: now}},
+			onChain:    8,
+			mpoolNonce: 7,
+			base:       8,
+			remain:     []uint64{9},
+		},
+	}
+
+	for _, tt := range tests {
+		wm := NewWalletManager()
+		wm.Config.NonceDiff = 3600
+		m := wm.NonceManager
+
+		m.mu.Lock()
+		entries := m.entries(testNonceAddress)
+		for _, r := range tt.entries {
+			r.Address = testNonceAddress
+			r.Key = nonceKey(testNonceAddress, r.Nonce)
+			entries[r.Nonce] = r
+		}
+		base := m.reconcile(testNonceAddress, tt.onChain, tt.mpoolNonce)
+		m.mu.Unlock()
+
+		if base != tt.base {
+			t.Errorf("%s: base = %d, want %d", tt.name, base, tt.base)
+		}
+		list := m.Reservations(testNonceAddress)
+		remain := make([]uint64, 0, len(list))
+		for _, r := range list {
+			remain = append(remain, r.Nonce)
+		}
+		if fmt.Sprint(remain) != fmt.Sprint(tt.remain) {
+			t.Errorf("%s: remain = %v, want %v", tt.name, remain, tt.remain)
+		}
+	}
+}
+
+func TestFreeRun(t *testing.T) {
+	used := func(nonces ...uint64) map[uint64]*NonceReservation {
+		entries := make(map[uint64]*NonceReservation)
+		for _, n := range nonces {
+			entries[n] = &NonceReservation{Nonce: n}
+		}
+		return entries
+	}
+
+	tests := []struct {
+		entries map[uint64]*NonceReservation
+		base    uint64
+		count   int
+		first   uint64
+	}{
+		{entries: used(), base: 5, count: 3, first: 5},
+		{entries: used(5, 6), base: 5, count: 1, first: 7},
+		{entries: used(6), base: 5, count: 1, first: 5},
+		{entries: used(6), base: 5, count: 2, first: 7},
+		{entries: used(7, 10), base: 5, count: 2, first: 5},
+		{entries: used(7, 10), base: 5, count: 3, first: 11},
+	}
+	for _, tt := range tests {
+		if first := freeRun(tt.entries, tt.base, tt.count); first != tt.first {
+			t.Errorf("freeRun(base %d, count %d) = %d, want %d", tt.base, tt.count, first, tt.first)
+		}
+	}
+}
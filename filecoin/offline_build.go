@@ -0,0 +1,155 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package filecoin
+
+import (
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/blocktree/openwallet/v2/openwallet"
+	"github.com/filecoin-project/go-address"
+)
+
+//FeeProfile 离线构建使用的手续费档位
+type FeeProfile struct {
+	Name       string
+	GasLimit   *big.Int
+	GasFeeCap  *big.Int
+	GasPremium *big.Int
+}
+
+//OfflineBuildParams 离线构建交易单所需的链上参数，由调用方提供
+type OfflineBuildParams struct {
+	From       string   //发送地址
+	Nonce      uint64   //发送地址的nonce
+	GasLimit   *big.Int //为空时取手续费档位的值
+	GasFeeCap  *big.Int //为空时取手续费档位的值
+	GasPremium *big.Int //为空时取手续费档位的值
+	FeeProfile string   //手续费档位名称
+	Method     uint64   //方法号，0为Send，转账给EVM合约时为InvokeEVM
+	Params     []byte   //方法参数
+}
+
+//parseFeeProfiles 解析手续费档位配置，格式：name:gasLimit:gasFeeCap:gasPremium,name:...
+func parseFeeProfiles(value string) (map[string]*FeeProfile, error) {
+	profiles := make(map[string]*FeeProfile)
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		fields := strings.Split(item, ":")
+		if len(fields) != 4 {
+			return nil, fmt.Errorf("fee profile %q should be name:gasLimit:gasFeeCap:gasPremium", item)
+		}
+		profile := &FeeProfile{Name: fields[0]}
+		values := make([]*big.Int, 3)
+		for i, field := range fields[1:] {
+			v, ok := new(big.Int).SetString(field, 10)
+			if !ok || v.Sign() < 0 {
+				return nil, fmt.Errorf("fee profile %q has invalid number %q", item, field)
+			}
+			values[i] = v
+		}
+		profile.GasLimit, profile.GasFeeCap, profile.GasPremium = values[0], values[1], values[2]
+		profiles[profile.Name] = profile
+	}
+	return profiles, nil
+}
+
+//feeInfo 合并显式参数与手续费档位，得到交易的手续费信息
+func (p *OfflineBuildParams) feeInfo(profiles map[string]*FeeProfile) (*txFeeInfo, error) {
+	feeInfo := &txFeeInfo{
+		GasLimit:   p.GasLimit,
+		GasFeeCap:  p.GasFeeCap,
+		GasPremium: p.GasPremium,
+	}
+
+	if p.FeeProfile != "" {
+		profile, ok := profiles[p.FeeProfile]
+		if !ok {
+			return nil, fmt.Errorf("fee profile %s is not configured", p.FeeProfile)
+		}
+		if feeInfo.GasLimit == nil {
+			feeInfo.GasLimit = profile.GasLimit
+		}
+		if feeInfo.GasFeeCap == nil {
+			feeInfo.GasFeeCap = profile.GasFeeCap
+		}
+		if feeInfo.GasPremium == nil {
+			feeInfo.GasPremium = profile.GasPremium
+		}
+	}
+
+	if feeInfo.GasLimit == nil || feeInfo.GasFeeCap == nil || feeInfo.GasPremium == nil {
+		return nil, fmt.Errorf("gas limit, gas fee cap and gas premium are required for offline build")
+	}
+	if feeInfo.GasLimit.Sign() <= 0 || !feeInfo.GasLimit.IsInt64() {
+		return nil, fmt.Errorf("gas limit %s is out of range", feeInfo.GasLimit)
+	}
+	if feeInfo.GasPremium.Cmp(feeInfo.GasFeeCap) > 0 {
+		return nil, fmt.Errorf("gas premium %s is greater than gas fee cap %s", feeInfo.GasPremium, feeInfo.GasFeeCap)
+	}
+
+	feeInfo.GasPrice = feeInfo.GasFeeCap
+	feeInfo.CalcFee()
+	return feeInfo, nil
+}
+
+//CreateOfflineRawTransaction 离线创建交易单，nonce与手续费由调用方提供，不访问lotus节点
+//f410地址发出的交易，接收地址须由调用方给出f0或f410地址，离线时无法把f1/f2/f3地址解析为ID地址
+//产出的 RawHex 与 KeySignature.Message 与在线构建一致，可直接交给 SignRawTransaction 签名
+func (decoder *TransactionDecoder) CreateOfflineRawTransaction(wrapper openwallet.WalletDAI, rawTx *openwallet.RawTransaction, params *OfflineBuildParams) error {
+	if params == nil || params.From == "" {
+		return openwallet.Errorf(openwallet.ErrCreateRawTransactionFailed, "offline build requires the from address")
+	}
+	if len(rawTx.To) != 1 {
+		return openwallet.Errorf(openwallet.ErrCreateRawTransactionFailed, "offline build supports exactly one receiver")
+	}
+
+	for to := range rawTx.To {
+		if err := offlineTarget(params.From, to); err != nil {
+			return err
+		}
+	}
+
+	feeInfo, err := params.feeInfo(decoder.wm.Config.FeeProfiles)
+	if err != nil {
+		return openwallet.Errorf(openwallet.ErrCreateRawTransactionFailed, "%v", err)
+	}
+
+	return decoder.createRawTransactionWithMethod(wrapper, rawTx, params.From, feeInfo, params.Nonce, params.Method, params.Params)
+}
+
+//offlineTarget 检查离线构建的接收地址，确保 messageTarget 不需要向节点查询ID地址
+func offlineTarget(from, to string) error {
+	fromAddr, err := address.NewFromString(from)
+	if err != nil {
+		return openwallet.Errorf(openwallet.ErrCreateRawTransactionFailed, "invalid from address %s, err=%v", from, err)
+	}
+	if fromAddr.Protocol() != address.Delegated {
+		return nil
+	}
+	toAddr, err := address.NewFromString(to)
+	if err != nil {
+		return openwallet.Errorf(openwallet.ErrCreateRawTransactionFailed, "invalid to address %s, err=%v", to, err)
+	}
+	if toAddr.Protocol() != address.ID && toAddr.Protocol() != address.Delegated {
+		return openwallet.Errorf(openwallet.ErrCreateRawTransactionFailed, "offline build from f410 address %s requires the id or f410 address of receiver %s", from, to)
+	}
+	return nil
+}
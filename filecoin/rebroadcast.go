@@ -0,0 +1,115 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package filecoin
+
+import (
+	"expvar"
+	"time"
+)
+
+const (
+	DefaultRebroadcastEpochs = 10 //默认不在内存池超过10个高度（约5分钟）后重新广播
+	DefaultMaxRebroadcasts   = 10 //默认最多重新广播次数
+)
+
+//rebroadcastMetrics 重新广播计数，通过 expvar 发布：attempts、succeeded、failed、dropped
+var rebroadcastMetrics = expvar.NewMap("filecoin_rebroadcast")
+
+//RebroadcastStats 重新广播计数
+type RebroadcastStats struct {
+	Attempts  int64
+	Succeeded int64
+	Failed    int64
+	Dropped   int64
+}
+
+//GetRebroadcastStats 进程启动以来的重新广播计数
+func GetRebroadcastStats() RebroadcastStats {
+	get := func(key string) int64 {
+		if v, ok := rebroadcastMetrics.Get(key).(*expvar.Int); ok {
+			return v.Value()
+		}
+		return 0
+	}
+	return RebroadcastStats{
+		Attempts:  get("attempts"),
+		Succeeded: get("succeeded"),
+		Failed:    get("failed"),
+		Dropped:   get("dropped"),
+	}
+}
+
+//rebroadcastDue 距最近一次广播是否已超过 RebroadcastEpochs 个高度；广播时还不知道链高度的，以本次高度重新计起
+func (t *MessageTracker) rebroadcastDue(message *TrackedMessage, height uint64) bool {
+	if height == 0 {
+		return false
+	}
+	if message.LastPushHeight == 0 {
+		message.LastPushHeight = height
+		t.update(message)
+		return false
+	}
+	return height >= message.LastPushHeight+t.wm.Config.RebroadcastEpochs
+}
+
+//rebroadcast 用保存的已签名消息重新 MpoolPush，失败也计入次数，下次到期再试
+func (t *MessageTracker) rebroadcast(message *TrackedMessage, height uint64) {
+	message.Rebroadcasts++
+	message.LastPushTime = time.Now().Unix()
+	message.LastPushHeight = height
+	rebroadcastMetrics.Add("attempts", 1)
+	t.wm.Log.Warningf("message %s of %s nonce %d is missing from mpool, rebroadcast %d/%d",
+		message.Cid, message.From, message.Nonce, message.Rebroadcasts, t.wm.Config.MaxRebroadcasts)
+
+	err := t.push(message)
+	if err != nil {
+		rebroadcastMetrics.Add("failed", 1)
+		t.wm.Log.Errorf("rebroadcast message %s failed: %v", message.Cid, err)
+	} else {
+		rebroadcastMetrics.Add("succeeded", 1)
+		t.wm.Log.Infof("rebroadcast message %s succeeded", message.Cid)
+	}
+	t.update(message)
+}
+
+func (t *MessageTracker) push(message *TrackedMessage) error {
+	signedMsg, err := newSignedMessage(message.RawMessage, message.Signature)
+	if err != nil {
+		return err
+	}
+	txid, err := t.wm.SendSignedMessage(signedMsg, t.wm.Config.AccessToken)
+	if err != nil {
+		return err
+	}
+	if txid != message.Cid {
+		t.wm.Log.Errorf("rebroadcast message cid mismatch, tracked: %s, node: %s", message.Cid, txid)
+	}
+	return nil
+}
+
+//update 保存跟踪中消息的变化，已结束跟踪的不再保存
+func (t *MessageTracker) update(message *TrackedMessage) {
+	message.UpdatedAt = time.Now().Unix()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if _, ok := t.pending[message.Cid]; !ok {
+		return
+	}
+	copied := *message
+	t.pending[message.Cid] = &copied
+	t.save(&copied)
+}
@@ -0,0 +1,198 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package filecoin
+
+import (
+	"math/big"
+
+	"github.com/blocktree/filecoin-adapter/filecoinTransaction"
+	"github.com/blocktree/filecoin-adapter/filecoin_addrdec"
+	"github.com/blocktree/openwallet/v2/openwallet"
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/specs-actors/actors/builtin"
+)
+
+const (
+	ReplaceByFeeRatioPercent = 125 //lotus 替换消息要求的最低premium比例（%）
+
+	ReplaceTypeSpeedUp = "speedup" //加速：相同消息，提高premium
+	ReplaceTypeCancel  = "cancel"  //取消：替换为给自己的0金额转账
+
+	ExtParamReplaceTxID = "replaceTxID" //被替换消息的CID
+	ExtParamReplaceType = "replaceType" //替换类型
+)
+
+//ComputeMinRBF 替换消息的最低premium，与lotus一致：原premium * 125% + 1
+func ComputeMinRBF(oldPremium *big.Int) *big.Int {
+	minPremium := new(big.Int).Mul(oldPremium, big.NewInt(ReplaceByFeeRatioPercent))
+	minPremium.Div(minPremium, big.NewInt(100))
+	return minPremium.Add(minPremium, big.NewInt(1))
+}
+
+//GetMessageByCid 按CID获取消息，内存池中未上链的消息同样可以查到
+func (wm *WalletManager) GetMessageByCid(txCid string) (*filecoinTransaction.Message, error) {
+	params := []interface{}{
+		map[string]interface{}{
+			"/": txCid,
+		},
+	}
+	result, err := wm.WalletClient.Call("Filecoin.ChainGetMessage", params)
+	if err != nil {
+		return nil, err
+	}
+	return filecoinTransaction.NewMessageFromJSON(result.Raw)
+}
+
+//CreateSpeedUpRawTransaction 创建加速交易单：nonce与内容不变，premium满足lotus的替换比例
+//交易单需继续走 SignRawTransaction/VerifyRawTransaction/SubmitRawTransaction，rawTx.Account 由调用方设置
+func (decoder *TransactionDecoder) CreateSpeedUpRawTransaction(wrapper openwallet.WalletDAI, rawTx *openwallet.RawTransaction, originTxID string) error {
+	return decoder.createReplaceRawTransaction(wrapper, rawTx, originTxID, ReplaceTypeSpeedUp)
+}
+
+//CreateCancelRawTransaction 创建取消交易单：用相同nonce给自己转0金额，替换掉原消息
+func (decoder *TransactionDecoder) CreateCancelRawTransaction(wrapper openwallet.WalletDAI, rawTx *openwallet.RawTransaction, originTxID string) error {
+	return decoder.createReplaceRawTransaction(wrapper, rawTx, originTxID, ReplaceTypeCancel)
+}
+
+func (decoder *TransactionDecoder) createReplaceRawTransaction(wrapper openwallet.WalletDAI, rawTx *openwallet.RawTransaction, originTxID, replaceType string) (err error) {
+	if rawTx.Account == nil {
+		return openwallet.Errorf(openwallet.ErrCreateRawTransactionFailed, "replace transaction requires the account")
+	}
+
+	tier, err := decoder.rawTxFeeTier(rawTx)
+	if err != nil {
+		return err
+	}
+
+	origin, err := decoder.wm.GetMessageByCid(originTxID)
+	if err != nil {
+		return openwallet.Errorf(openwallet.ErrCreateRawTransactionFailed, "get message %s failed, err=%v", originTxID, err)
+	}
+
+	from := decoder.wm.formatAddress(origin.From)
+
+	//与同一地址的其他构建串行，广播替换消息后释放
+	lease, err := decoder.wm.AddressLocker.Lock(decoder.wm.AddressLocker.Timeout(), from)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			lease.Unlock()
+			return
+		}
+		lease.Hold(map[string][]uint64{from: {origin.Nonce}})
+	}()
+
+	onChainNonce, err := decoder.wm.GetAddrOnChainNonce(from)
+	if err != nil {
+		return err
+	}
+	if onChainNonce > origin.Nonce {
+		return openwallet.Errorf(openwallet.ErrCreateRawTransactionFailed, "message %s with nonce %d is already on chain", originTxID, origin.Nonce)
+	}
+
+	var (
+		to           string
+		value        filecoinTransaction.FIL
+		method       = uint64(origin.Method)
+		methodParams = origin.Params
+	)
+	switch replaceType {
+	case ReplaceTypeSpeedUp:
+		to = decoder.wm.formatAddress(origin.To)
+		value, err = filecoinTransaction.NewFILFromBigInt(origin.Value)
+		if err != nil {
+			return openwallet.Errorf(openwallet.ErrCreateRawTransactionFailed, "message %s has invalid value: %v", originTxID, err)
+		}
+	case ReplaceTypeCancel:
+		to = from
+		value = filecoinTransaction.FIL{}
+		method = uint64(builtin.MethodSend)
+		methodParams = nil
+	default:
+		return openwallet.Errorf(openwallet.ErrCreateRawTransactionFailed, "unknown replace type %s", replaceType)
+	}
+
+	msgTo, msgMethod, msgParams, err := decoder.messageTarget(from, to, method, methodParams)
+	if err != nil {
+		return err
+	}
+	estimated, err := decoder.wm.GetTransactionFeeEstimatedWithTier(from, msgTo, value.AttoFIL(), origin.Nonce, msgMethod, msgParams, tier)
+	if err != nil {
+		return openwallet.Errorf(openwallet.ErrCreateRawTransactionFailed, "estimate fee failed, err=%v", err)
+	}
+
+	feeInfo := replaceFeeInfo(origin, estimated, replaceType)
+	//替换消息的premium不能低于lotus的替换比例，无法按档次下调，超出档次限制时拒绝创建
+	if err := tier.check(feeInfo); err != nil {
+		return openwallet.Errorf(openwallet.ErrCreateRawTransactionFailed, "replace message %s: %v", originTxID, err)
+	}
+
+	rawTx.To = map[string]string{to: value.Unitless()}
+	if err := decoder.createRawTransactionWithMethod(wrapper, rawTx, from, feeInfo, origin.Nonce, method, methodParams); err != nil {
+		return err
+	}
+
+	rawTx.SetExtParam(ExtParamReplaceTxID, originTxID)
+	rawTx.SetExtParam(ExtParamReplaceType, replaceType)
+	rawTx.SetExtParam(ExtParamFeeTier, tier.Name)
+
+	decoder.wm.Log.Infof("%s message %s of %s nonce %d, premium %s -> %s, fee cap %s -> %s",
+		replaceType, originTxID, from, origin.Nonce, origin.GasPremium, feeInfo.GasPremium, origin.GasFeeCap, feeInfo.GasFeeCap)
+	return nil
+}
+
+//replaceFeeInfo 计算替换消息的手续费
+//premium 取最低替换premium与当前估算的较大值，fee cap 不低于原值、估算值与premium
+func replaceFeeInfo(origin *filecoinTransaction.Message, estimated *txFeeInfo, replaceType string) *txFeeInfo {
+	gasPremium := maxBigInt(ComputeMinRBF(origin.GasPremium.Int), estimated.GasPremium)
+	gasFeeCap := maxBigInt(origin.GasFeeCap.Int, estimated.GasFeeCap, gasPremium)
+
+	//加速时消息内容不变，沿用原gas limit；取消为普通转账，使用估算值
+	gasLimit := estimated.GasLimit
+	if replaceType == ReplaceTypeSpeedUp {
+		gasLimit = big.NewInt(origin.GasLimit)
+	}
+
+	feeInfo := &txFeeInfo{
+		GasLimit:   gasLimit,
+		GasPrice:   gasFeeCap,
+		GasPremium: gasPremium,
+		GasFeeCap:  gasFeeCap,
+	}
+	feeInfo.CalcFee()
+	return feeInfo
+}
+
+//formatAddress 按当前网络的前缀输出地址，与钱包中保存的地址格式一致
+func (wm *WalletManager) formatAddress(addr address.Address) string {
+	prefix := filecoin_addrdec.MainnetPrefix
+	if wm.Config.isTestNet {
+		prefix = filecoin_addrdec.TestnetPrefix
+	}
+	return prefix + addr.String()[1:]
+}
+
+func maxBigInt(values ...*big.Int) *big.Int {
+	max := new(big.Int).Set(values[0])
+	for _, v := range values[1:] {
+		if v.Cmp(max) > 0 {
+			max.Set(v)
+		}
+	}
+	return max
+}
@@ -0,0 +1,209 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package filecoin
+
+import (
+	"fmt"
+	"math/big"
+	"sort"
+	"sync"
+
+	"github.com/filecoin-project/go-address"
+)
+
+const (
+	SenderSelectorLargestBalance   = "largestBalance"   //余额最大的地址
+	SenderSelectorSmallestCovering = "smallestCovering" //余额足够的地址中余额最小的一个
+	SenderSelectorFewestPending    = "fewestPending"    //内存池中待打包消息最少的地址
+	SenderSelectorRoundRobin       = "roundRobin"       //在余额足够的地址中轮流使用
+)
+
+//SenderCandidate 可作为发送地址的候选，只有余额足够且没有nonce断档的地址才会交给 SenderSelector
+type SenderCandidate struct {
+	Balance  *AddrBalance //余额与下一笔交易的nonce
+	Required *big.Int     //金额与手续费之和
+	Pending  int          //内存池中待打包的消息数
+}
+
+//SenderSelector 发送地址选择策略，candidates 不为空，返回其中之一
+type SenderSelector interface {
+	Name() string
+	Select(candidates []*SenderCandidate) *SenderCandidate
+}
+
+//NewSenderSelector 按名称创建内置的选择策略，名称为空时使用 smallestCovering
+func NewSenderSelector(name string) (SenderSelector, error) {
+	switch name {
+	case SenderSelectorLargestBalance:
+		return &LargestBalanceSelector{}, nil
+	case "", SenderSelectorSmallestCovering:
+		return &SmallestCoveringSelector{}, nil
+	case SenderSelectorFewestPending:
+		return &FewestPendingSelector{}, nil
+	case SenderSelectorRoundRobin:
+		return &RoundRobinSelector{}, nil
+	default:
+		return nil, fmt.Errorf("unknown sender selector %s", name)
+	}
+}
+
+//LargestBalanceSelector 选择余额最大的地址
+type LargestBalanceSelector struct{}
+
+func (s *LargestBalanceSelector) Name() string {
+	return SenderSelectorLargestBalance
+}
+
+func (s *LargestBalanceSelector) Select(candidates []*SenderCandidate) *SenderCandidate {
+	selected := candidates[0]
+	for _, c := range candidates[1:] {
+		if c.Balance.Balance.Cmp(selected.Balance.Balance) > 0 {
+			selected = c
+		}
+	}
+	return selected
+}
+
+//SmallestCoveringSelector 选择余额足够的地址中余额最小的一个，保留大额地址给大额出金
+type SmallestCoveringSelector struct{}
+
+func (s *SmallestCoveringSelector) Name() string {
+	return SenderSelectorSmallestCovering
+}
+
+func (s *SmallestCoveringSelector) Select(candidates []*SenderCandidate) *SenderCandidate {
+	selected := candidates[0]
+	for _, c := range candidates[1:] {
+		if c.Balance.Balance.Cmp(selected.Balance.Balance) < 0 {
+			selected = c
+		}
+	}
+	return selected
+}
+
+//FewestPendingSelector 选择内存池中待打包消息最少的地址，相同时取余额较大的
+type FewestPendingSelector struct{}
+
+func (s *FewestPendingSelector) Name() string {
+	return SenderSelectorFewestPending
+}
+
+func (s *FewestPendingSelector) Select(candidates []*SenderCandidate) *SenderCandidate {
+	selected := candidates[0]
+	for _, c := range candidates[1:] {
+		if c.Pending < selected.Pending ||
+			(c.Pending == selected.Pending && c.Balance.Balance.Cmp(selected.Balance.Balance) > 0) {
+			selected = c
+		}
+	}
+	return selected
+}
+
+//RoundRobinSelector 按地址顺序轮流选择，上次使用的地址之后的第一个候选
+type RoundRobinSelector struct {
+	mu   sync.Mutex
+	last string
+}
+
+func (s *RoundRobinSelector) Name() string {
+	return SenderSelectorRoundRobin
+}
+
+func (s *RoundRobinSelector) Select(candidates []*SenderCandidate) *SenderCandidate {
+	sorted := make([]*SenderCandidate, len(candidates))
+	copy(sorted, candidates)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Balance.Address < sorted[j].Balance.Address
+	})
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	selected := sorted[0]
+	for _, c := range sorted {
+		if c.Balance.Address > s.last {
+			selected = c
+			break
+		}
+	}
+	s.last = selected.Balance.Address
+	return selected
+}
+
+//GetMpoolPendingNonces 获取内存池中待打包消息的nonce，按发送地址分组，地址统一为f前缀
+func (wm *WalletManager) GetMpoolPendingNonces() (map[string][]uint64, error) {
+	messages, err := wm.GetMpoolPending()
+	if err != nil {
+		return nil, err
+	}
+
+	pending := make(map[string][]uint64)
+	for _, message := range messages {
+		a, err := address.NewFromString(message.From)
+		if err != nil {
+			continue
+		}
+		pending[a.String()] = append(pending[a.String()], message.Nonce)
+	}
+	return pending, nil
+}
+
+//pendingNonces 地址在内存池中的nonce，地址前缀不影响查找
+func pendingNonces(pending map[string][]uint64, addr string) []uint64 {
+	a, err := address.NewFromString(addr)
+	if err != nil {
+		return nil
+	}
+	return pending[a.String()]
+}
+
+//hasNonceGap 内存池中的nonce须从链上nonce开始连续，且下一笔交易的nonce紧接其后，否则存在未解决的断档
+func hasNonceGap(onChainNonce, nextNonce uint64, pending []uint64) bool {
+	sorted := make([]uint64, len(pending))
+	copy(sorted, pending)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i] < sorted[j]
+	})
+
+	expect := onChainNonce
+	for _, n := range sorted {
+		if n < onChainNonce {
+			//已上链，节点尚未清理
+			continue
+		}
+		if n != expect {
+			return true
+		}
+		expect++
+	}
+	return nextNonce != expect
+}
+
+//filterNonceGaps 去掉存在nonce断档的地址，返回剩余地址及各自的待打包消息数
+func (decoder *TransactionDecoder) filterNonceGaps(balances []AddrBalance, pending map[string][]uint64) ([]AddrBalance, map[string]int) {
+	result := make([]AddrBalance, 0, len(balances))
+	pendingCount := make(map[string]int)
+	for _, b := range balances {
+		nonces := pendingNonces(pending, b.Address)
+		if hasNonceGap(b.onChainNonce, b.Nonce, nonces) {
+			decoder.wm.Log.Warningf("skip %s, nonce gap: on chain %d, next %d, pending %v", b.Address, b.onChainNonce, b.Nonce, nonces)
+			continue
+		}
+		pendingCount[b.Address] = len(nonces)
+		result = append(result, b)
+	}
+	return result, pendingCount
+}
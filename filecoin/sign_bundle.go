@@ -0,0 +1,255 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package filecoin
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/blocktree/filecoin-adapter/filecoinTransaction"
+	"github.com/blocktree/openwallet/v2/hdkeystore"
+	"github.com/blocktree/openwallet/v2/openwallet"
+	"github.com/filecoin-project/go-address"
+)
+
+const (
+	SignBundleVersion = 1 //签名包格式版本
+)
+
+//SignBundle 冷签名包文件，checksum 为压缩后 payload 的 sha256
+type SignBundle struct {
+	Version  int             `json:"version"`
+	Checksum string          `json:"checksum"`
+	Payload  json.RawMessage `json:"payload"`
+}
+
+//SignBundlePayload 签名包内容，交易单的 KeySignature 中带有签名地址的HD路径
+type SignBundlePayload struct {
+	Symbol       string                       `json:"symbol"`
+	ChainID      uint64                       `json:"chainID"`
+	CreatedTime  int64                        `json:"createdTime"`
+	Transactions []*openwallet.RawTransaction `json:"transactions"`
+}
+
+//SignBundleResult 签名包中单笔交易的广播结果
+type SignBundleResult struct {
+	RawTx *openwallet.RawTransaction
+	Tx    *openwallet.Transaction
+	Error error
+}
+
+//NewSignBundle 把已构建未签名的交易单打包，用于带到离线机器签名
+func NewSignBundle(symbol string, chainID uint64, rawTxs []*openwallet.RawTransaction) ([]byte, error) {
+	for _, rawTx := range rawTxs {
+		if !rawTx.IsBuilt {
+			return nil, fmt.Errorf("transaction to %v is not built", rawTx.To)
+		}
+	}
+	return marshalSignBundle(&SignBundlePayload{
+		Symbol:       symbol,
+		ChainID:      chainID,
+		CreatedTime:  time.Now().Unix(),
+		Transactions: rawTxs,
+	})
+}
+
+//CreateSignBundle 构建一批交易单并打包为签名包，任一交易构建失败时整个签名包失败
+//同一发送地址的多笔交易共用签名包的地址锁，依次预留连续的nonce；nonce与地址锁不过期，
+//持有到 SubmitSignBundle 广播包内交易，或 CancelSignBundle 取消签名包
+func (decoder *TransactionDecoder) CreateSignBundle(wrapper openwallet.WalletDAI, rawTxs []*openwallet.RawTransaction) (data []byte, err error) {
+	bundle := decoder.wm.AddressLocker.Bundle()
+	defer func() {
+		if err != nil {
+			for address, nonces := range bundle.Nonces() {
+				decoder.wm.NonceManager.Release(address, nonces...)
+			}
+			bundle.Unlock()
+			return
+		}
+		for address, nonces := range bundle.Nonces() {
+			decoder.wm.NonceManager.Bundle(address, nonces...)
+		}
+		bundle.Hold(bundle.Nonces())
+	}()
+
+	for _, rawTx := range rawTxs {
+		if err := decoder.createFilRawTransaction(wrapper, rawTx, bundle); err != nil {
+			return nil, fmt.Errorf("build transaction to %v failed: %v", rawTx.To, err)
+		}
+	}
+	return NewSignBundle(decoder.wm.Symbol(), decoder.wm.Config.ChainID, rawTxs)
+}
+
+//ParseSignBundle 解析签名包并校验版本与checksum
+func ParseSignBundle(data []byte) (*SignBundlePayload, error) {
+	var bundle SignBundle
+	if err := json.Unmarshal(data, &bundle); err != nil {
+		return nil, fmt.Errorf("invalid sign bundle: %v", err)
+	}
+	if bundle.Version != SignBundleVersion {
+		return nil, fmt.Errorf("unsupported sign bundle version %d", bundle.Version)
+	}
+	if len(bundle.Checksum) == 0 || bundleChecksum(bundle.Payload) != bundle.Checksum {
+		return nil, fmt.Errorf("sign bundle checksum mismatch")
+	}
+
+	var payload SignBundlePayload
+	if err := json.Unmarshal(bundle.Payload, &payload); err != nil {
+		return nil, fmt.Errorf("invalid sign bundle payload: %v", err)
+	}
+	return &payload, nil
+}
+
+//SignBundleOffline 离线签名入口，用HD私钥对签名包内的交易单签名，返回带签名的新签名包
+//待签哈希由 RawHex 重新计算并与 KeySignature.Message 比对，不信任包内的待签消息
+func SignBundleOffline(data []byte, key *hdkeystore.HDKey) ([]byte, error) {
+	payload, err := ParseSignBundle(data)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, rawTx := range payload.Transactions {
+		rawMessages, keySignatures, err := rawMessageSignatures(rawTx)
+		if err != nil {
+			return nil, fmt.Errorf("transaction to %v: %v", rawTx.To, err)
+		}
+
+		//多接收方交易单的消息与签名按顺序一一对应
+		for i, keySignature := range keySignatures {
+			message, err := filecoinTransaction.NewMessageFromJSON(rawMessages[i])
+			if err != nil {
+				return nil, fmt.Errorf("transaction to %v has invalid raw message: %v", rawTx.To, err)
+			}
+			hash, err := message.SigningHash(payload.ChainID)
+			if err != nil {
+				return nil, err
+			}
+
+			if keySignature.Address == nil {
+				return nil, fmt.Errorf("signature part of transaction to %v has no address", rawTx.To)
+			}
+			//地址按字节比较，兼容t/f前缀
+			signer, err := address.NewFromString(keySignature.Address.Address)
+			if err != nil || signer != message.From {
+				return nil, fmt.Errorf("signer %s does not match message sender %s", keySignature.Address.Address, message.From)
+			}
+			if keySignature.Message != hex.EncodeToString(hash) {
+				return nil, fmt.Errorf("signing message of %s does not match its raw message", message.From)
+			}
+
+			signature, err := signKeySignature(key, keySignature)
+			if err != nil {
+				return nil, err
+			}
+
+			//签名后立即校验，确认HD路径派生的私钥属于发送地址
+			if _, pass := filecoinTransaction.VerifyAndCombineTransactionWithChainID(rawMessages[i], signature, payload.ChainID); !pass {
+				return nil, fmt.Errorf("signature of %s does not verify, check the hd path %s", message.From, keySignature.Address.HDPath)
+			}
+			keySignature.Signature = signature
+		}
+	}
+
+	return marshalSignBundle(payload)
+}
+
+//SubmitSignBundle 导入离线签名后的签名包，逐笔验证并广播；验证失败的交易释放其nonce与地址锁
+func (decoder *TransactionDecoder) SubmitSignBundle(wrapper openwallet.WalletDAI, data []byte) ([]*SignBundleResult, error) {
+	payload, err := ParseSignBundle(data)
+	if err != nil {
+		return nil, err
+	}
+	if payload.Symbol != decoder.wm.Symbol() {
+		return nil, fmt.Errorf("sign bundle symbol %s does not match %s", payload.Symbol, decoder.wm.Symbol())
+	}
+	if payload.ChainID != decoder.wm.Config.ChainID {
+		return nil, fmt.Errorf("sign bundle chain id %d does not match %d", payload.ChainID, decoder.wm.Config.ChainID)
+	}
+
+	results := make([]*SignBundleResult, 0, len(payload.Transactions))
+	for _, rawTx := range payload.Transactions {
+		result := &SignBundleResult{RawTx: rawTx}
+		results = append(results, result)
+
+		if result.Error = decoder.VerifyRawTransaction(wrapper, rawTx); result.Error != nil {
+			decoder.releaseBundled(rawTx)
+			continue
+		}
+		if !rawTx.IsCompleted {
+			result.Error = fmt.Errorf("transaction to %v signature verify failed", rawTx.To)
+			decoder.releaseBundled(rawTx)
+			continue
+		}
+		result.Tx, result.Error = decoder.SubmitRawTransaction(wrapper, rawTx)
+	}
+	return results, nil
+}
+
+//CancelSignBundle 放弃未广播的签名包，释放包内仍未广播的nonce与地址锁
+func (decoder *TransactionDecoder) CancelSignBundle(data []byte) error {
+	payload, err := ParseSignBundle(data)
+	if err != nil {
+		return err
+	}
+	for _, rawTx := range payload.Transactions {
+		decoder.releaseBundled(rawTx)
+	}
+	return nil
+}
+
+//releaseBundled 释放交易单中仍在签名包里的nonce，并移除对应的地址锁持有
+func (decoder *TransactionDecoder) releaseBundled(rawTx *openwallet.RawTransaction) {
+	rawMessages, keySignatures, err := rawMessageSignatures(rawTx)
+	if err != nil {
+		decoder.wm.Log.Warningf("release bundled transaction to %v failed: %v", rawTx.To, err)
+		return
+	}
+	for i, keySignature := range keySignatures {
+		message, err := filecoinTransaction.NewMessageFromJSON(rawMessages[i])
+		if err != nil || keySignature.Address == nil {
+			continue
+		}
+		from := keySignature.Address.Address
+		decoder.wm.NonceManager.Unbundle(from, message.Nonce)
+		decoder.wm.AddressLocker.Unlock(from, message.Nonce)
+	}
+}
+
+func marshalSignBundle(payload *SignBundlePayload) ([]byte, error) {
+	raw, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	return json.MarshalIndent(&SignBundle{
+		Version:  SignBundleVersion,
+		Checksum: bundleChecksum(raw),
+		Payload:  raw,
+	}, "", "  ")
+}
+
+//bundleChecksum 对压缩后的payload计算checksum，文件被重新排版不影响校验
+func bundleChecksum(payload []byte) string {
+	compact := new(bytes.Buffer)
+	if err := json.Compact(compact, payload); err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(compact.Bytes())
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,79 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package filecoin
+
+import (
+	"fmt"
+	"math/big"
+
+	"github.com/blocktree/openwallet/v2/openwallet"
+	"github.com/shopspring/decimal"
+)
+
+//summaryBaseFeeGuard 当前base fee超过 SummaryMaxBaseFee 时返回推迟汇总的原因，未配置时不查询
+func (decoder *TransactionDecoder) summaryBaseFeeGuard() (string, error) {
+	maxBaseFee := decoder.wm.Config.SummaryMaxBaseFee
+	if maxBaseFee == nil || maxBaseFee.Sign() <= 0 {
+		return "", nil
+	}
+	if err := decoder.wm.UpdateBaseFeeHistory(); err != nil {
+		return "", err
+	}
+	latest := decoder.wm.BaseFeeHistory.Latest()
+	if latest == nil {
+		return "", fmt.Errorf("base fee history is empty")
+	}
+	if latest.BaseFee.Cmp(maxBaseFee) > 0 {
+		reason := fmt.Sprintf("base fee %s at height %d is above %s, summary deferred", latest.BaseFee, latest.Height, maxBaseFee)
+		decoder.wm.Log.Warningf("%s", reason)
+		return reason, nil
+	}
+	return "", nil
+}
+
+//summaryFeeRatioGuard 手续费超过汇总金额的 SummaryMaxFeePercent% 时返回跳过的原因
+func (decoder *TransactionDecoder) summaryFeeRatioGuard(fee, amount *big.Int) string {
+	maxPercent := decoder.wm.Config.SummaryMaxFeePercent
+	if !maxPercent.IsPositive() {
+		return ""
+	}
+	//fee * 100 > amount * percent，不做除法避免精度损失
+	if decimal.NewFromBigInt(fee, 2).GreaterThan(decimal.NewFromBigInt(amount, 0).Mul(maxPercent)) {
+		return fmt.Sprintf("fee %s is above %s%% of summary amount %s", formatAttoFIL(fee), maxPercent.String(), formatAttoFIL(amount))
+	}
+	return ""
+}
+
+//skippedSummaryRawTransaction 被跳过地址的交易单，只有地址与金额，没有构建
+func skippedSummaryRawTransaction(sumRawTx *openwallet.SummaryRawTransaction, address string, amount *big.Int, reason string) *openwallet.RawTransactionWithError {
+	amountStr := formatAttoFIL(amount)
+	return &openwallet.RawTransactionWithError{
+		RawTx: &openwallet.RawTransaction{
+			Coin:     sumRawTx.Coin,
+			Account:  sumRawTx.Account,
+			ExtParam: sumRawTx.ExtParam,
+			To: map[string]string{
+				sumRawTx.SummaryAddress: amountStr,
+			},
+			TxFrom:   []string{address},
+			TxTo:     []string{sumRawTx.SummaryAddress},
+			TxAmount: amountStr,
+			Required: 1,
+			FeeRate:  sumRawTx.FeeRate,
+		},
+		Error: openwallet.Errorf(openwallet.ErrCreateRawTransactionFailed, "%s", reason),
+	}
+}
@@ -20,10 +20,10 @@ import (
 	"encoding/json"
 	"fmt"
 	"github.com/blocktree/filecoin-adapter/filecoinTransaction"
-	"github.com/blocktree/openwallet/v2/common"
+	"github.com/blocktree/filecoin-adapter/filecoin_rpc"
+	"github.com/blocktree/openwallet/v2/hdkeystore"
 	"github.com/ethereum/go-ethereum/common/math"
 	"github.com/filecoin-project/go-address"
-	"github.com/filecoin-project/go-state-types/abi"
 	"github.com/filecoin-project/specs-actors/actors/builtin"
 	"github.com/shopspring/decimal"
 	"math/big"
@@ -73,192 +73,404 @@ func (decoder *TransactionDecoder) SubmitRawTransaction(wrapper openwallet.Walle
 		return nil, fmt.Errorf("transaction is not completed validation")
 	}
 
-	from := rawTx.Signatures[rawTx.Account.AccountID][0].Address.Address
-	nonce := rawTx.Signatures[rawTx.Account.AccountID][0].Nonce
-	nonceUint, _ := strconv.ParseUint(nonce[2:], 16, 64)
-
-	decoder.wm.Log.Info("nonce : ", nonceUint, " update from : ", from)
-
-	message, err := filecoinTransaction.NewMessageFromJSON( rawTx.RawHex )
-	if err!=nil {
-		return nil, fmt.Errorf("transaction is not wrong message format")
+	rawMessages, keySignatures, err := rawMessageSignatures(rawTx)
+	if err != nil {
+		return nil, err
+	}
+	if len(rawMessages) > 1 {
+		return decoder.submitMultiRecipientTransaction(wrapper, rawTx, rawMessages, keySignatures)
 	}
 
-	sig := rawTx.Signatures[rawTx.Account.AccountID][0].Signature
-
-	now1 := decimal.NewFromInt( time.Now().UnixNano() )
+	from := keySignatures[0].Address.Address
+	nonce := keySignatures[0].Nonce
+	nonceUint, _ := strconv.ParseUint(nonce[2:], 16, 64)
 
-	txid, err := decoder.wm.SendRawTransaction(message, sig, decoder.wm.Config.AccessToken) //.SendRawTransaction(message, sig)
+	decoder.wm.Log.Info("nonce : ", nonceUint, " update from : ", from)
 
-	now2 := decimal.NewFromInt( time.Now().UnixNano() )
-	cha := now2.Sub( now1).Div( decimal.NewFromInt( 1e9 ) )
+	//广播结束后释放构建时持有的地址锁
+	defer decoder.wm.AddressLocker.Unlock(from, nonceUint)
 
+	//广播前先记录本地计算的CID，广播超时等情况下仍可按此追踪交易
+	localCid, err := localMessageCid(rawTx.RawHex, keySignatures[0].Signature)
 	if err != nil {
-		decoder.wm.Log.Errorf("send_to_%v_" + strconv.FormatUint(message.Nonce, 10) + ", use : " + cha.String() + "s, now1 :" + now1.String() + ", now2 : " + now2.String(), rawTx.To )
-
-		decoder.wm.UpdateAddressNonce(wrapper, from, 0)
-		decoder.wm.Log.Error("Error Tx to send: ", rawTx.RawHex)
 		return nil, err
 	}
+	rawTx.TxID = localCid
+	decoder.wm.MessageTracker.Track(localCid, from, nonceUint, rawTx.RawHex, keySignatures[0].Signature)
 
-	//交易成功，地址nonce+1并记录到缓存
-	newNonce, _ := math.SafeAdd(nonceUint, uint64(1)) //nonce+1
-	decoder.wm.UpdateAddressNonce(wrapper, from, newNonce)
+	txid, err := decoder.sendRawMessage(rawTx.RawHex, keySignatures[0].Signature)
+	if err != nil {
+		decoder.submitFailed(from, nonceUint, localCid, err)
+		return nil, err
+	}
+	decoder.submitSucceeded(from, nonceUint, localCid, txid, rawTx.RawHex, keySignatures[0].Signature)
 
 	rawTx.TxID = txid
 	rawTx.IsSubmit = true
 
-	decimals := int32(6)
-
 	tx := openwallet.Transaction{
 		From:       rawTx.TxFrom,
 		To:         rawTx.TxTo,
 		Amount:     rawTx.TxAmount,
 		Coin:       rawTx.Coin,
 		TxID:       rawTx.TxID,
-		Decimal:    decimals,
+		Decimal:    decoder.wm.Decimal(),
 		AccountID:  rawTx.Account.AccountID,
 		Fees:       rawTx.Fees,
 		SubmitTime: time.Now().Unix(),
 	}
 
+	//替换消息记录被替换消息的CID，便于追踪两者的对应关系
+	if replaceTxID := rawTx.GetExtParam().Get(ExtParamReplaceTxID).String(); replaceTxID != "" {
+		tx.SetExtParam(ExtParamReplaceTxID, replaceTxID)
+		tx.SetExtParam(ExtParamReplaceType, rawTx.GetExtParam().Get(ExtParamReplaceType).String())
+		decoder.wm.Log.Infof("message %s is replaced by %s", replaceTxID, txid)
+	}
+
 	tx.WxID = openwallet.GenTransactionWxID(&tx)
 
 	return &tx, nil
 }
 
-func (decoder *TransactionDecoder) CreateFilRawTransaction(wrapper openwallet.WalletDAI, rawTx *openwallet.RawTransaction) error {
-	var (
-		feeInfo         *txFeeInfo
-	)
+//submitSucceeded 交易成功，确认nonce，广播时间由 NonceManager 记录，汇总时据此跳过刚出金的地址
+func (decoder *TransactionDecoder) submitSucceeded(from string, nonce uint64, localCid, txid, rawMessage, signature string) {
+	decoder.wm.NonceManager.Confirm(from, nonce, txid)
+	if txid != localCid {
+		//节点返回的CID与本地计算的不一致，说明节点改写了消息或签名类型，以节点为准，改为跟踪节点返回的CID
+		decoder.wm.Log.Errorf("message cid mismatch, local: %s, node: %s, raw: %s", localCid, txid, rawMessage)
+		decoder.wm.MessageTracker.Reject(localCid, fmt.Sprintf("node returned cid %s", txid))
+		decoder.wm.MessageTracker.Track(txid, from, nonce, rawMessage, signature)
+	}
+}
 
-	addresses, err := wrapper.GetAddressList(0, -1, "AccountID", rawTx.Account.AccountID)
+//submitRejections 节点确定不会接收该消息的报错，其余报错（包括其他RPC错误）视为结果未知
+var submitRejections = []string{
+	"nonce too low",
+	"minimum expected nonce",
+	"not enough funds",
+	"insufficient funds",
+	"insufficient balance",
+	"invalid signature",
+	"signature verification failed",
+	"already in mpool", //相同nonce的其他消息已在内存池，本消息未被接收
+}
 
+//submitRejected 广播报错是否为节点明确拒绝
+func submitRejected(err error) bool {
+	rpcErr, ok := err.(*filecoin_rpc.Error)
+	if !ok {
+		return false
+	}
+	message := strings.ToLower(rpcErr.Message)
+	for _, reason := range submitRejections {
+		if strings.Contains(message, reason) {
+			return true
+		}
+	}
+	return false
+}
+
+//submitFailed 广播失败时，只有节点明确拒绝的消息释放nonce并结束跟踪；超时等结果未知时nonce不再分配，避免下次构建重复使用，
+//消息仍在跟踪中，不在内存池时由 MessageTracker 重新广播
+func (decoder *TransactionDecoder) submitFailed(from string, nonce uint64, localCid string, err error) {
+	if localCid == "" {
+		//未能计算CID的消息不会广播出去
+		decoder.wm.NonceManager.Release(from, nonce)
+		return
+	}
+	if submitRejected(err) {
+		decoder.wm.NonceManager.Release(from, nonce)
+		decoder.wm.MessageTracker.Reject(localCid, err.Error())
+		return
+	}
+	decoder.wm.NonceManager.Retire(from, nonce, localCid)
+}
+
+//localMessageCid 本地计算已签名消息的CID
+func localMessageCid(rawMessage, signature string) (string, error) {
+	signedMsg, err := newSignedMessage(rawMessage, signature)
 	if err != nil {
-		return err
+		return "", err
 	}
+	c, err := signedMsg.Cid()
+	if err != nil {
+		return "", err
+	}
+	return c.String(), nil
+}
 
-	if len(addresses) == 0 {
-		return openwallet.Errorf(openwallet.ErrAccountNotAddress, "[%s] have not addresses", rawTx.Account.AccountID)
+func newSignedMessage(rawMessage, signature string) (*filecoinTransaction.SignedMessage, error) {
+	message, err := filecoinTransaction.NewMessageFromJSON(rawMessage)
+	if err != nil {
+		return nil, fmt.Errorf("transaction is not wrong message format")
 	}
 
-	var amountStr, to string
-	for k, v := range rawTx.To {
-		to = k
-		amountStr = v
-		break
+	sigData, err := hex.DecodeString(signature)
+	if err != nil {
+		return nil, fmt.Errorf("transaction signature is not valid hex")
 	}
 
-	amountBigInt := common.StringNumToBigIntWithExp(amountStr, decoder.wm.Decimal())
+	return filecoinTransaction.NewSignedMessage(message, sigData)
+}
 
-	addressesBalanceList := make([]AddrBalance, 0, len(addresses))
+//sendRawMessage 广播一条已签名消息，返回节点给出的CID
+func (decoder *TransactionDecoder) sendRawMessage(rawMessage, signature string) (string, error) {
+	signedMsg, err := newSignedMessage(rawMessage, signature)
+	if err != nil {
+		return "", err
+	}
+	c, err := signedMsg.Cid()
+	if err != nil {
+		return "", err
+	}
+	localCid := c.String()
 
-	var feeErr error
-	for i, addr := range addresses {
-		balance, err := decoder.wm.GetAddrBalance(addr.Address) //decoder.wm.ApiClient.getBalance(addr.Address, decoder.wm.Config.IgnoreReserve, decoder.wm.Config.ReserveAmount)
-		if err != nil {
-			return err
+	now1 := decimal.NewFromInt( time.Now().UnixNano() )
+
+	txid, err := decoder.wm.SendSignedMessage(signedMsg, decoder.wm.Config.AccessToken)
+
+	now2 := decimal.NewFromInt( time.Now().UnixNano() )
+	cha := now2.Sub( now1).Div( decimal.NewFromInt( 1e9 ) )
+
+	if err != nil {
+		decoder.wm.Log.Errorf("send_to_%v_" + strconv.FormatUint(signedMsg.Message.Nonce, 10) + ", use : " + cha.String() + "s, now1 :" + now1.String() + ", now2 : " + now2.String(), signedMsg.Message.To )
+		decoder.wm.Log.Error("Error Tx to send: ", rawMessage)
+		//重复广播（如超时后重试）时节点已收到同一消息，按成功处理
+		if decoder.messageAccepted(localCid) {
+			decoder.wm.Log.Warningf("message %s was already accepted by node, submit as success: %v", localCid, err)
+			return localCid, nil
 		}
-		
-		if decimal.NewFromBigInt(balance.Balance, 0).LessThanOrEqual(decimal.Zero) {
-			continue
+		return "", err
+	}
+	return txid, nil
+}
+
+//messageAccepted 广播报错时，按本地CID确认节点是否已收到同一消息：已上链或已在内存池
+//lotus 对相同nonce的其他消息也会返回 already in mpool，不能只凭报错判断
+func (decoder *TransactionDecoder) messageAccepted(localCid string) bool {
+	lookup, lookupErr := decoder.wm.StateSearchMsg(localCid, lookbackNoLimit)
+	if lookupErr == nil && lookup != nil && lookup.Cid == localCid {
+		return true
+	}
+
+	pending, lookupErr := decoder.wm.GetMpoolPending()
+	if lookupErr != nil {
+		return false
+	}
+	for _, message := range pending {
+		if message.Cid == localCid {
+			return true
 		}
-		
-		nonce_onchain, err := decoder.wm.GetAddrOnChainNonce(addr.Address)
+	}
+	return false
+}
+
+//CreateFilRawTransaction 创建交易单，rawTx.To 有多个接收方时，每个接收方一条消息，使用同一发送地址的连续nonce
+func (decoder *TransactionDecoder) CreateFilRawTransaction(wrapper openwallet.WalletDAI, rawTx *openwallet.RawTransaction) error {
+	return decoder.createFilRawTransaction(wrapper, rawTx, decoder.wm.AddressLocker)
+}
+
+//createFilRawTransaction 创建交易单，发送地址由 locker 加锁，签名包构建时为签名包的 lease
+func (decoder *TransactionDecoder) createFilRawTransaction(wrapper openwallet.WalletDAI, rawTx *openwallet.RawTransaction, locker senderLocker) error {
+	recipients, err := parseRecipients(rawTx.To)
+	if err != nil {
+		return err
+	}
+
+	var totalAmount filecoinTransaction.FIL
+	for _, r := range recipients {
+		//按目标actor类型决定是否允许发送，以及使用的方法号
+		r.Method, r.Params, err = decoder.destinationMethod(r.To)
 		if err != nil {
 			return err
 		}
-		nonce := decoder.wm.GetAddressNonce(wrapper, addr.Address, nonce_onchain)
-		balance.Nonce = nonce
+		totalAmount = totalAmount.Add(r.Amount)
+	}
+	first := recipients[0]
+
+	//FeeRate 会被构建结果覆盖，先确定手续费档次
+	tier, err := decoder.rawTxFeeTier(rawTx)
+	if err != nil {
+		return err
+	}
+
+	balances, err := decoder.accountBalances(wrapper, rawTx.Account.AccountID)
+	if err != nil {
+		return err
+	}
+	pending, err := decoder.wm.GetMpoolPendingNonces()
+	if err != nil {
+		return err
+	}
+	//有nonce断档的地址不参与选择，避免新消息卡在断档之后
+	candidates, pendingCount := decoder.filterNonceGaps(balances, pending)
+
+	addressesBalanceList := make([]AddrBalance, 0, len(candidates))
+	feeInfos := make(map[string]*txFeeInfo)
+
+	var feeErr error
+	for _, balance := range candidates {
+		msgTo, msgMethod, msgParams, targetErr := decoder.messageTarget(balance.Address, first.To, first.Method, first.Params)
+		if targetErr != nil {
+			feeErr = targetErr
+			continue
+		}
 
 		//计算手续费
-		feeInfo, feeErr = decoder.wm.GetTransactionFeeEstimated(addr.Address, to, amountBigInt, nonce)
-		if feeErr != nil {
+		feeInfo, err := decoder.wm.GetTransactionFeeEstimatedWithTier(balance.Address, msgTo, first.Amount.AttoFIL(), balance.Nonce, msgMethod, msgParams, tier)
+		if err != nil {
+			feeErr = err
 			continue
 		}
+		feeInfos[balance.Address] = feeInfo
 
 		//if rawTx.FeeRate != "" {
 		//	feeInfo.GasFeeCap = common.StringNumToBigIntWithExp(rawTx.FeeRate, decoder.wm.Decimal())
 		//	feeInfo.CalcFee()
 		//}
 
-		balance.index = i
-		addressesBalanceList = append(addressesBalanceList, *balance)
+		addressesBalanceList = append(addressesBalanceList, balance)
 	}
 
-	sort.Slice(addressesBalanceList, func(i int, j int) bool {
-		return addressesBalanceList[i].Balance.Cmp(addressesBalanceList[j].Balance) >= 0
-	})
-
-	if feeInfo==nil && feeErr!=nil {
-		return feeErr
+	//余额足够支付全部金额与手续费的地址交给选择策略，其余消息的手续费按第一条估算
+	eligible := make([]*SenderCandidate, 0, len(addressesBalanceList))
+	for i := range addressesBalanceList {
+		a := &addressesBalanceList[i]
+		fees := new(big.Int).Mul(feeInfos[a.Address].Fee, big.NewInt(int64(len(recipients))))
+		required := new(big.Int).Add(totalAmount.AttoFIL(), fees)
+		if a.Balance.Cmp(required) >= 0 {
+			eligible = append(eligible, &SenderCandidate{Balance: a, Required: required, Pending: pendingCount[a.Address]})
+		}
 	}
-	fee := feeInfo.Fee.Uint64()
-	//if len(rawTx.FeeRate) > 0 {
-	//	fee = uint64(decoder.wm.Config.FixedFee) //convertFromAmount(rawTx.FeeRate)
-	//} else {
-	//	fee = feeInfo.Fee.Uint64()
-	//}
 
-	from := ""
-	nonce := uint64(0)
-	for _, a := range addressesBalanceList {
-		from = a.Address
-		nonce = a.Nonce
+	var sender *AddrBalance
+	if len(eligible) > 0 {
+		sender = decoder.wm.SenderSelector.Select(eligible).Balance
+		decoder.wm.Log.Debugf("sender %s selected by %s from %d candidates", sender.Address, decoder.wm.SenderSelector.Name(), len(eligible))
 	}
 
-	if from == "" {
-		return openwallet.Errorf(openwallet.ErrInsufficientBalanceOfAccount, "the balance: %s is not enough", amountStr)
+	if sender == nil {
+		//没有单个地址足够时，可由多个地址分别出金
+		if len(recipients) == 1 && len(candidates) > 0 && decoder.multiSourceEnabled(rawTx) {
+			_, err := decoder.createMultiSourceRawTransaction(wrapper, rawTx, first, candidates, tier, locker)
+			return err
+		}
+		if len(feeInfos) == 0 && feeErr != nil {
+			return feeErr
+		}
+		return openwallet.Errorf(openwallet.ErrInsufficientBalanceOfAccount, "the balance: %s is not enough", totalAmount.Unitless())
 	}
+	from := sender.Address
 
-	nonceMap := map[string]uint64{
-		from: nonce,
+	//构建→签名→广播期间独占发送地址
+	lease, err := locker.Lock(decoder.wm.AddressLocker.Timeout(), from)
+	if err != nil {
+		return err
 	}
 
-	rawTx.TxFrom = []string{from}
-	rawTx.TxTo = []string{to}
-	rawTx.SetExtParam("nonce", nonceMap)
-	rawTx.TxAmount = amountStr
-
-	gasFeeCap := common.BigIntToDecimals(feeInfo.GasFeeCap, decoder.wm.Decimal())
-	rawTx.FeeRate = gasFeeCap.String()
-
-	totalFeeDecimal := common.BigIntToDecimals(feeInfo.Fee, decoder.wm.Decimal())
-	rawTx.Fees = totalFeeDecimal.String()
+	//每个接收方一个连续nonce，构建失败时释放
+	nonce, err := decoder.wm.NonceManager.Reserve(from, len(recipients))
+	if err != nil {
+		lease.Unlock()
+		return err
+	}
+	built := false
+	defer func() {
+		if !built {
+			decoder.wm.NonceManager.Release(from, nonceRange(nonce, len(recipients))...)
+			lease.Unlock()
+			return
+		}
+		lease.Hold(map[string][]uint64{from: nonceRange(nonce, len(recipients))})
+	}()
 
 	decoder.wm.Log.Debugf("nonce: %d", nonce)
 
-	emptyTrans, message, err := decoder.CreateEmptyRawTransactionAndMessage(from, to, amountStr, nonce, decoder.wm.Decimal(), feeInfo) //.CreateEmptyRawTransactionAndMessage(fromPub, hex.EncodeToString(toPub), amount, nonce, fee, mostHeightBlock)
+	addr, err := wrapper.GetAddress(from)
 	if err != nil {
 		return err
 	}
-	rawTx.RawHex = emptyTrans
 
-	if rawTx.Signatures == nil {
-		rawTx.Signatures = make(map[string][]*openwallet.KeySignature)
+	var (
+		rawMessages = make([]string, 0, len(recipients))
+		keySigs     = make([]*openwallet.KeySignature, 0, len(recipients))
+		tos         = make([]string, 0, len(recipients))
+		totalFee    = new(big.Int)
+		gasFeeCap   = new(big.Int)
+	)
+	for i, r := range recipients {
+		msgNonce := nonce + uint64(i)
+
+		msgTo, msgMethod, msgParams, err := decoder.messageTarget(from, r.To, r.Method, r.Params)
+		if err != nil {
+			return err
+		}
+
+		//第一条消息沿用选择发送地址时的估算，其余消息或预留的nonce有变化时按各自的nonce与接收方估算
+		feeInfo := feeInfos[from]
+		if i > 0 || nonce != sender.Nonce {
+			feeInfo, err = decoder.wm.GetTransactionFeeEstimatedWithTier(from, msgTo, r.Amount.AttoFIL(), msgNonce, msgMethod, msgParams, tier)
+			if err != nil {
+				return err
+			}
+		}
+
+		emptyTrans, message, err := decoder.createEmptyRawTransactionAndMessageWithMethod(from, msgTo, r.Amount.Unitless(), msgNonce, feeInfo, msgMethod, msgParams) //.CreateEmptyRawTransactionAndMessage(fromPub, hex.EncodeToString(toPub), amount, nonce, fee, mostHeightBlock)
+		if err != nil {
+			return err
+		}
+		rawMessages = append(rawMessages, emptyTrans)
+		tos = append(tos, r.To)
+		totalFee.Add(totalFee, feeInfo.Fee)
+		gasFeeCap = maxBigInt(gasFeeCap, feeInfo.GasFeeCap)
+		decoder.wm.NonceManager.SetSpend(from, msgNonce, new(big.Int).Add(r.Amount.AttoFIL(), feeInfo.Fee))
+
+		keySigs = append(keySigs, &openwallet.KeySignature{
+			EccType: decoder.wm.Config.CurveType,
+			Nonce:   "0x" + strconv.FormatUint(msgNonce, 16),
+			Address: addr,
+			Message: message,
+			RSV : true,
+		})
 	}
 
-	keySigs := make([]*openwallet.KeySignature, 0)
+	//选择发送地址时未加锁，持有地址锁后重新读取余额，扣除内存池中与已构建未广播的消息后需覆盖所有接收方的金额与手续费上限
+	required := new(big.Int).Add(totalAmount.AttoFIL(), totalFee)
+	spendable, err := decoder.spendableBalance(from, nonceRange(nonce, len(recipients))...)
+	if err != nil {
+		return err
+	}
+	if spendable.Cmp(required) < 0 {
+		return openwallet.Errorf(openwallet.ErrInsufficientBalanceOfAccount, "the spendable balance of %s: %s is not enough for %s with fees %s", from, formatAttoFIL(spendable), totalAmount.Unitless(), formatAttoFIL(totalFee))
+	}
 
-	addr, err := wrapper.GetAddress(from)
+	rawHex, err := joinRawMessages(rawMessages)
 	if err != nil {
 		return err
 	}
-	signature := openwallet.KeySignature{
-		EccType: decoder.wm.Config.CurveType,
-		Nonce:   "0x" + strconv.FormatUint(nonce, 16),
-		Address: addr,
-		Message: message,
-		RSV : true,
+
+	nonceMap := map[string]uint64{
+		from: nonce,
 	}
 
-	keySigs = append(keySigs, &signature)
+	rawTx.TxFrom = []string{from}
+	rawTx.TxTo = tos
+	rawTx.SetExtParam("nonce", nonceMap)
+	rawTx.TxAmount = totalAmount.Unitless()
+	rawTx.Fees = formatAttoFIL(totalFee)
+	rawTx.RawHex = rawHex
 
+	if rawTx.Signatures == nil {
+		rawTx.Signatures = make(map[string][]*openwallet.KeySignature)
+	}
 	rawTx.Signatures[rawTx.Account.AccountID] = keySigs
 
-	rawTx.FeeRate = big.NewInt(0).SetUint64(fee).String()
+	rawTx.SetExtParam(ExtParamFeeTier, tier.Name)
+	//FeeRate 为每gas的手续费上限，单位FIL，多条消息时取最大的 fee cap
+	rawTx.FeeRate = formatAttoFIL(gasFeeCap)
 
 	rawTx.IsBuilt = true
+	built = true
 
 	return nil
 }
@@ -273,20 +485,11 @@ func (decoder *TransactionDecoder) SignFilRawTransaction(wrapper openwallet.Wall
 
 	if keySignatures != nil {
 		for _, keySignature := range keySignatures {
-
-			childKey, err := key.DerivedKeyWithPath(keySignature.Address.HDPath, keySignature.EccType)
-			keyBytes, err := childKey.GetPrivateKeyBytes()
+			signature, err := signKeySignature(key, keySignature)
 			if err != nil {
 				return err
 			}
-
-			//签名交易
-			///////交易单哈希签名
-			signature, err := filecoinTransaction.SignTransaction(keySignature.Message, keyBytes)
-			if err != nil {
-				return fmt.Errorf("transaction hash sign failed, unexpected error: %v", err)
-			}
-			keySignature.Signature = hex.EncodeToString(signature)
+			keySignature.Signature = signature
 		}
 	}
 
@@ -295,26 +498,47 @@ func (decoder *TransactionDecoder) SignFilRawTransaction(wrapper openwallet.Wall
 	return nil
 }
 
-func (decoder *TransactionDecoder) VerifyFILRawTransaction(wrapper openwallet.WalletDAI, rawTx *openwallet.RawTransaction) error {
+//signKeySignature 按签名结构中的HD路径派生私钥并签名待签消息
+func signKeySignature(key *hdkeystore.HDKey, keySignature *openwallet.KeySignature) (string, error) {
+	childKey, err := key.DerivedKeyWithPath(keySignature.Address.HDPath, keySignature.EccType)
+	if err != nil {
+		return "", err
+	}
+	keyBytes, err := childKey.GetPrivateKeyBytes()
+	if err != nil {
+		return "", err
+	}
 
-	var (
-		emptyTrans = rawTx.RawHex
-		signature  = ""
-	)
+	//签名交易
+	///////交易单哈希签名
+	signature, err := filecoinTransaction.SignTransaction(keySignature.Message, keyBytes)
+	if err != nil {
+		return "", fmt.Errorf("transaction hash sign failed, unexpected error: %v", err)
+	}
+	return hex.EncodeToString(signature), nil
+}
 
-	for accountID, keySignatures := range rawTx.Signatures {
-		log.Debug("accountID Signatures:", accountID)
-		for _, keySignature := range keySignatures {
+func (decoder *TransactionDecoder) VerifyFILRawTransaction(wrapper openwallet.WalletDAI, rawTx *openwallet.RawTransaction) error {
+
+	rawMessages, keySignatures, err := rawMessageSignatures(rawTx)
+	if err != nil {
+		log.Debug("transaction verify failed:", err)
+		rawTx.IsCompleted = false
+		return nil
+	}
 
-			signature = keySignature.Signature
+	//多接收方交易单的每条消息都需验证通过
+	pass := true
+	for i, keySignature := range keySignatures {
+		log.Debug("Signature:", keySignature.Signature)
+		log.Debug("PublicKey:", keySignature.Address.PublicKey)
 
-			log.Debug("Signature:", keySignature.Signature)
-			log.Debug("PublicKey:", keySignature.Address.PublicKey)
+		if _, ok := filecoinTransaction.VerifyAndCombineTransactionWithChainID(rawMessages[i], keySignature.Signature, decoder.wm.Config.ChainID); !ok {
+			pass = false
+			break
 		}
 	}
 
-	_, pass := filecoinTransaction.VerifyAndCombineTransaction(emptyTrans, signature)
-
 	if pass {
 		log.Debug("transaction verify passed")
 		rawTx.IsCompleted = true
@@ -327,9 +551,22 @@ func (decoder *TransactionDecoder) VerifyFILRawTransaction(wrapper openwallet.Wa
 	return nil
 }
 
+//GetRawTransactionFeeRate 按近期base fee与premium估算的每gas费率，标准转账的预计手续费见 GetStandardSendFee
 func (decoder *TransactionDecoder) GetRawTransactionFeeRate() (feeRate string, unit string, err error) {
-	pricedecimal := common.BigIntToDecimals(decoder.wm.Config.FixGasPrice, decoder.wm.Decimal())
-	return pricedecimal.String(), "Gas", nil
+	estimate, err := decoder.wm.GetFeeRateEstimate(DefaultFeePercentile)
+	if err != nil {
+		return "", "", err
+	}
+	return formatAttoFIL(estimate.FeeRate), "Gas", nil
+}
+
+//GetStandardSendFee 按 GetRawTransactionFeeRate 的费率估算标准转账的预计手续费与手续费上限，单位FIL
+func (decoder *TransactionDecoder) GetStandardSendFee() (fee string, maxFee string, err error) {
+	estimate, err := decoder.wm.GetFeeRateEstimate(DefaultFeePercentile)
+	if err != nil {
+		return "", "", err
+	}
+	return formatAttoFIL(estimate.Fee), formatAttoFIL(estimate.MaxFee), nil
 }
 
 //CreateSummaryRawTransaction 创建汇总交易，返回原始交易单数组
@@ -342,18 +579,82 @@ func (decoder *TransactionDecoder) CreateSummaryRawTransaction(wrapper openwalle
 }
 
 func (decoder *TransactionDecoder) CreateSimpleSummaryRawTransaction(wrapper openwallet.WalletDAI, sumRawTx *openwallet.SummaryRawTransaction) ([]*openwallet.RawTransaction, error) {
+	rawTxWithErrArray, err := decoder.createSimpleSummaryRawTransaction(wrapper, sumRawTx)
+	if err != nil {
+		return nil, err
+	}
+	rawTxArray := make([]*openwallet.RawTransaction, 0, len(rawTxWithErrArray))
+	for _, rawTxWithErr := range rawTxWithErrArray {
+		if rawTxWithErr.Error == nil {
+			rawTxArray = append(rawTxArray, rawTxWithErr.RawTx)
+		}
+	}
+	return rawTxArray, nil
+}
+
+//createSimpleSummaryRawTransaction 创建汇总交易，被手续费保护跳过的地址带原因返回
+func (decoder *TransactionDecoder) createSimpleSummaryRawTransaction(wrapper openwallet.WalletDAI, sumRawTx *openwallet.SummaryRawTransaction) ([]*openwallet.RawTransactionWithError, error) {
 
 	var (
-		rawTxArray      = make([]*openwallet.RawTransaction, 0)
+		rawTxArray      = make([]*openwallet.RawTransactionWithError, 0)
 		accountID       = sumRawTx.Account.AccountID
-		minTransfer     = ConvertFromAmount(sumRawTx.MinTransfer, decoder.wm.Decimal())
-		retainedBalance = ConvertFromAmount(sumRawTx.RetainedBalance, decoder.wm.Decimal())
 	)
 
+	minTransfer, err := filecoinTransaction.ParseFIL(sumRawTx.MinTransfer)
+	if err != nil {
+		return nil, err
+	}
+	retainedBalance, err := filecoinTransaction.ParseFIL(sumRawTx.RetainedBalance)
+	if err != nil {
+		return nil, err
+	}
+
 	if minTransfer.Cmp(retainedBalance) < 0 {
 		return nil, fmt.Errorf("mini transfer amount must be greater than address retained balance")
 	}
 
+	tier, err := decoder.sumRawTxFeeTier(sumRawTx)
+	if err != nil {
+		return nil, err
+	}
+
+	//base fee 超过阈值时推迟本次汇总，待汇总的地址全部带原因返回
+	deferReason, err := decoder.summaryBaseFeeGuard()
+	if err != nil {
+		return nil, err
+	}
+
+	//每个地址加锁并预留一个nonce，地址被跳过或整批失败时释放
+	reserved := make(map[string]uint64)
+	leases := make(map[string]*AddressLease)
+	releaseNonce := func(address string) {
+		if nonce, ok := reserved[address]; ok {
+			decoder.wm.NonceManager.Release(address, nonce)
+			delete(reserved, address)
+		}
+		if lease, ok := leases[address]; ok {
+			lease.Unlock()
+			delete(leases, address)
+		}
+	}
+	done := false
+	defer func() {
+		if !done {
+			for address := range leases {
+				releaseNonce(address)
+			}
+			return
+		}
+		for address, lease := range leases {
+			held := make(map[string][]uint64)
+			if nonce, ok := reserved[address]; ok {
+				held[address] = []uint64{nonce}
+			}
+			lease.Hold(held)
+			delete(leases, address)
+		}
+	}()
+
 	//获取wallet
 	addresses, err := wrapper.GetAddressList(sumRawTx.AddressStartIndex, sumRawTx.AddressLimit,
 		"AccountID", sumRawTx.Account.AccountID)
@@ -377,9 +678,9 @@ func (decoder *TransactionDecoder) CreateSimpleSummaryRawTransaction(wrapper ope
 
 	//地址余额从大到小排序
 	sort.Slice(addrBalanceArray, func(i int, j int) bool {
-		a_amount, _ := decimal.NewFromString(addrBalanceArray[i].Balance)
-		b_amount, _ := decimal.NewFromString(addrBalanceArray[j].Balance)
-		if a_amount.LessThan(b_amount) {
+		a_amount, _ := filecoinTransaction.ParseFIL(addrBalanceArray[i].Balance)
+		b_amount, _ := filecoinTransaction.ParseFIL(addrBalanceArray[j].Balance)
+		if a_amount.Cmp(b_amount) < 0 {
 			return true
 		} else {
 			return false
@@ -389,46 +690,71 @@ func (decoder *TransactionDecoder) CreateSimpleSummaryRawTransaction(wrapper ope
 	for _, addrBalance := range addrBalanceArray {
 
 		//检查余额是否超过最低转账
-		addrBalance_BI := ConvertFromAmount(addrBalance.Balance, decoder.wm.Decimal())
+		addrBalanceFIL, err := filecoinTransaction.ParseFIL(addrBalance.Balance)
+		if err != nil {
+			decoder.wm.Log.Std.Error("invalid balance %v of address %v, err=%v", addrBalance.Balance, addrBalance.Address, err)
+			continue
+		}
 
-		if addrBalance_BI.Cmp(minTransfer) < 0 {
+		if addrBalanceFIL.Cmp(minTransfer) < 0 {
 			continue
 		}
 
 		//计算汇总数量 = 余额 - 保留余额
 		sumAmount_BI := new(big.Int)
-		sumAmount_BI.Sub(addrBalance_BI, retainedBalance)
-
-		nonce_db, _ := wrapper.GetAddressExtParam(addrBalance.Address, addrBalance.Symbol + "-nonce")
-		if nonce_db != nil {
-			nonceStr := common.NewString(nonce_db)
-			nonceStrArr := strings.Split(string(nonceStr), "_")
-			if len(nonceStrArr)>1 {
-				saveTime := common.NewString(nonceStrArr[1]).UInt64()	//上次汇总此地址的时间，秒为单位
-				now := uint64(time.Now().Unix())
-				diff, _ := math.SafeSub(now, saveTime)
-
-				if diff < decoder.wm.Config.LessSumDiff {	// 少于配置的时间，就不要汇总此地址了
-					decoder.wm.Log.Std.Error("%v address nonce diff = %d ", addrBalance.Address, diff)
-					continue
-				}
+		sumAmount_BI.Sub(addrBalanceFIL.AttoFIL(), retainedBalance.AttoFIL())
+
+		if deferReason != "" {
+			rawTxArray = append(rawTxArray, skippedSummaryRawTransaction(sumRawTx, addrBalance.Address, sumAmount_BI, deferReason))
+			continue
+		}
+
+		//上次广播消息的时间，秒为单位，少于配置的时间就不要汇总此地址了
+		if lastSubmit := decoder.wm.NonceManager.LastSubmitTime(addrBalance.Address); lastSubmit > 0 {
+			diff, _ := math.SafeSub(uint64(time.Now().Unix()), uint64(lastSubmit))
+			if diff < decoder.wm.Config.LessSumDiff {
+				decoder.wm.Log.Std.Error("%v address last submit diff = %d ", addrBalance.Address, diff)
+				continue
 			}
 		}
 
-		addrOnChainNonce, err := decoder.wm.GetAddrOnChainNonce( addrBalance.Address )
+		//地址正在出金时不等待，留到下次汇总
+		lease, err := decoder.wm.AddressLocker.Lock(0, addrBalance.Address)
 		if err != nil {
-			decoder.wm.Log.Std.Error("Failed to get nonce when create summay transaction! %v ", addrBalance.Address)
+			decoder.wm.Log.Warningf("skip summary of %s: %v", addrBalance.Address, err)
+			continue
+		}
+		leases[addrBalance.Address] = lease
+
+		nonce, err := decoder.wm.NonceManager.Reserve(addrBalance.Address, 1)
+		if err != nil {
+			decoder.wm.Log.Std.Error("Failed to get nonce when create summay transaction! %v, err=%v", addrBalance.Address, err)
+			releaseNonce(addrBalance.Address)
+			continue
+		}
+		reserved[addrBalance.Address] = nonce
+
+		msgTo, msgMethod, msgParams, createErr := decoder.messageTarget(addrBalance.Address, sumRawTx.SummaryAddress, uint64(builtin.MethodSend), nil)
+		if createErr != nil {
+			decoder.wm.Log.Std.Error("messageTarget from[%v] -> to[%v] failed, err=%v", addrBalance.Address, sumRawTx.SummaryAddress, createErr)
+			releaseNonce(addrBalance.Address)
 			continue
 		}
-		nonce := decoder.wm.GetAddressNonce(wrapper, addrBalance.Address, addrOnChainNonce)
 
 		//计算手续费
-		fee, createErr := decoder.wm.GetTransactionFeeEstimated(addrBalance.Address, sumRawTx.SummaryAddress, sumAmount_BI, nonce)
+		fee, createErr := decoder.wm.GetTransactionFeeEstimatedWithTier(addrBalance.Address, msgTo, sumAmount_BI, nonce, msgMethod, msgParams, tier)
 		if createErr != nil {
 			decoder.wm.Log.Std.Error("GetTransactionFeeEstimated from[%v] -> to[%v] failed, err=%v", addrBalance.Address, sumRawTx.SummaryAddress, createErr)
 			return nil, createErr
 		}
 
+		if reason := decoder.summaryFeeRatioGuard(fee.Fee, sumAmount_BI); reason != "" {
+			decoder.wm.Log.Warningf("skip summary of %s: %s", addrBalance.Address, reason)
+			rawTxArray = append(rawTxArray, skippedSummaryRawTransaction(sumRawTx, addrBalance.Address, sumAmount_BI, reason))
+			releaseNonce(addrBalance.Address)
+			continue
+		}
+
 		//if sumRawTx.FeeRate != "" {
 		//	fee.GasPrice = common.StringNumToBigIntWithExp(sumRawTx.FeeRate, decoder.wm.Decimal()) //ConvertToBigInt(rawTx.FeeRate, 16)
 		//	if createErr != nil {
@@ -441,11 +767,12 @@ func (decoder *TransactionDecoder) CreateSimpleSummaryRawTransaction(wrapper ope
 		//减去手续费
 		sumAmount_BI.Sub(sumAmount_BI, fee.Fee)
 		if sumAmount_BI.Cmp(big.NewInt(0)) <= 0 {
+			releaseNonce(addrBalance.Address)
 			continue
 		}
 
-		sumAmount := common.BigIntToDecimals(sumAmount_BI, decoder.wm.Decimal())
-		fees := common.BigIntToDecimals(fee.Fee, decoder.wm.Decimal())
+		sumAmount := formatAttoFIL(sumAmount_BI)
+		fees := formatAttoFIL(fee.Fee)
 
 		decoder.wm.Log.Info(
 			"summary_log_1, address : ", addrBalance.Address,
@@ -460,7 +787,7 @@ func (decoder *TransactionDecoder) CreateSimpleSummaryRawTransaction(wrapper ope
 			Account:  sumRawTx.Account,
 			ExtParam: sumRawTx.ExtParam,
 			To: map[string]string{
-				sumRawTx.SummaryAddress: sumAmount.StringFixed(decoder.wm.Decimal()),
+				sumRawTx.SummaryAddress: sumAmount,
 			},
 			Required: 1,
 			FeeRate:  sumRawTx.FeeRate,
@@ -476,14 +803,59 @@ func (decoder *TransactionDecoder) CreateSimpleSummaryRawTransaction(wrapper ope
 			decoder.wm.Log.Std.Error("createRawTransaction, err=%v", addrBalance.Address, sumRawTx.SummaryAddress, createErr)
 			return nil, createErr
 		}
+		rawTx.SetExtParam(ExtParamFeeTier, tier.Name)
 
 		//创建成功，添加到队列
-		rawTxArray = append(rawTxArray, rawTx)
+		rawTxArray = append(rawTxArray, &openwallet.RawTransactionWithError{
+			RawTx: rawTx,
+			Error: nil,
+		})
 	}
+	done = true
 	return rawTxArray, nil
 }
 
 func (decoder *TransactionDecoder) createRawTransaction(wrapper openwallet.WalletDAI, rawTx *openwallet.RawTransaction, addrBalance *openwallet.Balance, feeInfo *txFeeInfo, nonce uint64) error {
+	return decoder.createRawTransactionWithMethod(wrapper, rawTx, addrBalance.Address, feeInfo, nonce, uint64(builtin.MethodSend), nil)
+}
+
+//spendableBalance 地址的链上余额扣除内存池中待打包消息与已构建未广播消息的金额和手续费上限，exclude 为本次构建预留的nonce
+//需在持有地址锁时调用，锁外的构建与广播不会改变结果
+func (decoder *TransactionDecoder) spendableBalance(from string, exclude ...uint64) (*big.Int, error) {
+	balance, err := decoder.wm.GetAddrBalance(from)
+	if err != nil {
+		return nil, err
+	}
+	addr, err := address.NewFromString(from)
+	if err != nil {
+		return nil, err
+	}
+	pending, err := decoder.wm.GetMpoolPending()
+	if err != nil {
+		return nil, err
+	}
+
+	spendable := new(big.Int)
+	if balance.Balance != nil {
+		spendable.Set(balance.Balance)
+	}
+	for _, message := range pending {
+		if sender, err := address.NewFromString(message.From); err != nil || sender != addr {
+			continue
+		}
+		value, err := filecoinTransaction.ParseFIL(message.Value)
+		if err != nil {
+			return nil, fmt.Errorf("pending message %s has invalid value: %v", message.Cid, err)
+		}
+		spendable.Sub(spendable, value.AttoFIL())
+		spendable.Sub(spendable, new(big.Int).Mul(message.GasFeeCap, big.NewInt(message.GasLimit)))
+	}
+	spendable.Sub(spendable, decoder.wm.NonceManager.ReservedSpend(from, exclude...))
+	return spendable, nil
+}
+
+//createRawTransactionWithMethod 用给定的手续费、nonce与方法号构建交易单，不查询链上状态（f410地址发给f1/f2/f3地址时需查询其ID地址）
+func (decoder *TransactionDecoder) createRawTransactionWithMethod(wrapper openwallet.WalletDAI, rawTx *openwallet.RawTransaction, from string, feeInfo *txFeeInfo, nonce uint64, method uint64, methodParams []byte) error {
 
 	var amountStr, to string
 	for k, v := range rawTx.To {
@@ -492,7 +864,6 @@ func (decoder *TransactionDecoder) createRawTransaction(wrapper openwallet.Walle
 		break
 	}
 
-	from := addrBalance.Address
 	fromAddr, err := wrapper.GetAddress(from)
 	if err != nil {
 		return err
@@ -502,11 +873,9 @@ func (decoder *TransactionDecoder) createRawTransaction(wrapper openwallet.Walle
 	rawTx.TxTo = []string{to}
 	rawTx.TxAmount = amountStr
 
-	gasFeeCap := common.BigIntToDecimals(feeInfo.GasFeeCap, decoder.wm.Decimal())
-	rawTx.FeeRate = gasFeeCap.String()
-
-	totalFeeDecimal := common.BigIntToDecimals(feeInfo.Fee, decoder.wm.Decimal())
-	rawTx.Fees = totalFeeDecimal.String()
+	//FeeRate 为每gas的手续费上限（fee cap），单位FIL，与 GetRawTransactionFeeRate 一致
+	rawTx.FeeRate = formatAttoFIL(feeInfo.GasFeeCap)
+	rawTx.Fees = formatAttoFIL(feeInfo.Fee)
 
 	nonceJSON := map[string]interface{}{}
 	if len(rawTx.ExtParam) > 0 {
@@ -519,7 +888,12 @@ func (decoder *TransactionDecoder) createRawTransaction(wrapper openwallet.Walle
 
 	rawTx.SetExtParam("nonce", nonceJSON)
 
-	emptyTrans, hash, err := decoder.CreateEmptyRawTransactionAndMessage(from, to, amountStr, nonce, decoder.wm.Decimal(), feeInfo) //.CreateEmptyRawTransactionAndMessage(fromAddr.PublicKey, hex.EncodeToString(toPub), amount, nonce, fee, mostHeightBlock)
+	msgTo, msgMethod, msgParams, err := decoder.messageTarget(from, to, method, methodParams)
+	if err != nil {
+		return err
+	}
+
+	emptyTrans, hash, err := decoder.createEmptyRawTransactionAndMessageWithMethod(from, msgTo, amountStr, nonce, feeInfo, msgMethod, msgParams) //.CreateEmptyRawTransactionAndMessage(fromAddr.PublicKey, hex.EncodeToString(toPub), amount, nonce, fee, mostHeightBlock)
 
 	if err != nil {
 		return err
@@ -544,50 +918,115 @@ func (decoder *TransactionDecoder) createRawTransaction(wrapper openwallet.Walle
 
 	rawTx.Signatures[rawTx.Account.AccountID] = keySigs
 
-	rawTx.FeeRate = feeInfo.Fee.String()
-
 	rawTx.IsBuilt = true
 
 	return nil
 }
 
 //CreateSummaryRawTransactionWithError 创建汇总交易，返回能原始交易单数组（包含带错误的原始交易单）
+//被手续费保护跳过的地址同样返回，Error 中为跳过的原因
 func (decoder *TransactionDecoder) CreateSummaryRawTransactionWithError(wrapper openwallet.WalletDAI, sumRawTx *openwallet.SummaryRawTransaction) ([]*openwallet.RawTransactionWithError, error) {
-	raTxWithErr := make([]*openwallet.RawTransactionWithError, 0)
-	rawTxs, err := decoder.CreateSummaryRawTransaction(wrapper, sumRawTx)
-	if err != nil {
-		return nil, err
-	}
-	for _, tx := range rawTxs {
-		raTxWithErr = append(raTxWithErr, &openwallet.RawTransactionWithError{
-			RawTx: tx,
-			Error: nil,
-		})
+	if sumRawTx.Coin.IsContract {
+		return make([]*openwallet.RawTransactionWithError, 0), nil
 	}
-	return raTxWithErr, nil
+	return decoder.createSimpleSummaryRawTransaction(wrapper, sumRawTx)
 }
 
+//CreateEmptyRawTransactionAndMessage 创建转账的空交易单和待签消息，realAmountStr 为 decimals 精度下的金额
+//Deprecated: 金额统一以 FIL 为单位，保留此方法仅为兼容已有调用方
 func (decoder *TransactionDecoder) CreateEmptyRawTransactionAndMessage(from, to, realAmountStr string, nonce uint64, decimals int32, feeInfo *txFeeInfo) (string, string, error) {
-	fromAddr, _ := address.NewFromString(from )
-	toAddr, _ := address.NewFromString(to)
+	amount, err := decimal.NewFromString(realAmountStr)
+	if err != nil {
+		return "", "", openwallet.Errorf(openwallet.ErrCreateRawTransactionFailed, "invalid amount %s, err=%v", realAmountStr, err)
+	}
+	//decimals 精度下的金额换算为 FIL
+	filAmount := amount.Shift(decimals - filecoinTransaction.FILDecimals).String()
+	return decoder.createEmptyRawTransactionAndMessageWithMethod(from, to, filAmount, nonce, feeInfo, uint64(builtin.MethodSend), nil)
+}
 
-	valueStr := GetBigIntAmountStr(realAmountStr, decimals)
-	value, _ := filecoinTransaction.BigFromString( valueStr )
+func (decoder *TransactionDecoder) createEmptyRawTransactionAndMessageWithMethod(from, to, realAmountStr string, nonce uint64, feeInfo *txFeeInfo, method uint64, methodParams []byte) (string, string, error) {
+	fromAddr, err := address.NewFromString(from)
+	if err != nil {
+		return "", "", openwallet.Errorf(openwallet.ErrCreateRawTransactionFailed, "invalid from address %s, err=%v", from, err)
+	}
+	toAddr, err := address.NewFromString(to)
+	if err != nil {
+		return "", "", openwallet.Errorf(openwallet.ErrCreateRawTransactionFailed, "invalid to address %s, err=%v", to, err)
+	}
 
-	method := builtin.MethodSend
+	value, err := filecoinTransaction.ParseFIL(realAmountStr)
+	if err != nil {
+		return "", "", openwallet.Errorf(openwallet.ErrCreateRawTransactionFailed, "%v", err)
+	}
 
-	msg := filecoinTransaction.Message{
-		To:       toAddr,
-		From:     fromAddr,
-		Value:    value,
-		//GasPrice: filecoinTransaction.NewInt( uint64(gasPrice.Int64()) ),
-		GasFeeCap: abi.NewTokenAmount( feeInfo.GasFeeCap.Int64() ),
-		GasPremium: abi.NewTokenAmount( feeInfo.GasPremium.Int64() ),
-		Nonce:    nonce,
-		GasLimit: feeInfo.GasLimit.Int64(),
-		Method:   method,
+	//金额与手续费全程保持大整数，避免超过 int64 时溢出
+	msg, err := filecoinTransaction.NewMessageWithFee(fromAddr, toAddr, nonce, value.BigInt(),
+		filecoinTransaction.BigInt{Int: feeInfo.GasLimit},
+		filecoinTransaction.BigInt{Int: feeInfo.GasFeeCap},
+		filecoinTransaction.BigInt{Int: feeInfo.GasPremium},
+		method, methodParams)
+	if err != nil {
+		return "", "", openwallet.Errorf(openwallet.ErrCreateRawTransactionFailed, "%v", err)
+	}
+
+	//f410地址签名的是 EIP-1559 交易的哈希
+	if fromAddr.Protocol() == address.Delegated {
+		return msg.CreateEmptyDelegatedTransactionAndMessage(decoder.wm.Config.ChainID)
 	}
 
 	// 创建空交易单和待签消息
 	return msg.CreateEmptyTransactionAndMessage()
 }
+
+//messageTarget 返回消息实际使用的接收地址、方法号与参数
+//f410地址发出的消息只能用InvokeEVM，接收地址须为f0或f410地址，f1/f2/f3地址需先解析为ID地址
+func (decoder *TransactionDecoder) messageTarget(from, to string, method uint64, methodParams []byte) (string, uint64, []byte, error) {
+	fromAddr, err := address.NewFromString(from)
+	if err != nil {
+		return "", 0, nil, openwallet.Errorf(openwallet.ErrCreateRawTransactionFailed, "invalid from address %s, err=%v", from, err)
+	}
+	if fromAddr.Protocol() != address.Delegated {
+		return to, method, methodParams, nil
+	}
+
+	toAddr, err := address.NewFromString(to)
+	if err != nil {
+		return "", 0, nil, openwallet.Errorf(openwallet.ErrCreateRawTransactionFailed, "invalid to address %s, err=%v", to, err)
+	}
+	msgTo := to
+	if toAddr.Protocol() != address.ID && toAddr.Protocol() != address.Delegated {
+		msgTo, err = decoder.wm.AddressResolver.ToID(to)
+		if err != nil {
+			return "", 0, nil, openwallet.Errorf(openwallet.ErrCreateRawTransactionFailed, "%s has no id address yet, f410 senders can not send to it, err=%v", to, err)
+		}
+	}
+
+	if method != Message_Method_InvokeEVM {
+		methodParams = nil
+	}
+	return msgTo, Message_Method_InvokeEVM, methodParams, nil
+}
+
+//destinationMethod 按目标地址的actor类型执行发送策略，返回转账使用的方法号与参数
+func (decoder *TransactionDecoder) destinationMethod(to string) (uint64, []byte, error) {
+	actorType, err := decoder.wm.GetActorType(to)
+	if err != nil {
+		return 0, nil, openwallet.Errorf(openwallet.ErrCreateRawTransactionFailed, "get actor type of %s failed, err=%v", to, err)
+	}
+
+	switch actorType {
+	case ActorTypeNone, ActorTypeAccount, ActorTypeEthAccount, ActorTypePlaceholder, ActorTypeMultisig, ActorTypePaymentChannel:
+		return uint64(builtin.MethodSend), nil, nil
+	case ActorTypeMiner:
+		if decoder.wm.Config.MinerSendPolicy == MinerSendPolicyReject {
+			return 0, nil, openwallet.Errorf(openwallet.ErrCreateRawTransactionFailed, "%s is a miner actor, plain send is rejected", to)
+		}
+		decoder.wm.Log.Warningf("%s is a miner actor, the funds will be added to the miner's balance", to)
+		return uint64(builtin.MethodSend), nil, nil
+	case ActorTypeEVM:
+		//参数为CBOR编码的空字节串
+		return Message_Method_InvokeEVM, []byte{0x40}, nil
+	default:
+		return 0, nil, openwallet.Errorf(openwallet.ErrCreateRawTransactionFailed, "%s is a %s actor, plain send is not supported", to, actorType)
+	}
+}
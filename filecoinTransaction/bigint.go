@@ -5,6 +5,7 @@ import (
 	"math/big"
 
 	big2 "github.com/filecoin-project/go-state-types/big"
+	"github.com/shopspring/decimal"
 )
 
 const TotalFilecoin = uint64(2_000_000_000)
@@ -39,6 +40,27 @@ func BigFromString(s string) (BigInt, error) {
 	return BigInt{Int: v}, nil
 }
 
+//ParseTokenAmount 把带小数的金额字符串精确转换为最小单位，超出精度的小数位返回错误
+func ParseTokenAmount(amountStr string, decimals int32) (BigInt, error) {
+	d, err := decimal.NewFromString(amountStr)
+	if err != nil {
+		return EmptyInt, fmt.Errorf("invalid amount %q: %v", amountStr, err)
+	}
+	d = d.Shift(decimals)
+	if !d.Equal(d.Truncate(0)) {
+		return EmptyInt, fmt.Errorf("amount %q has more than %d decimal places", amountStr, decimals)
+	}
+	return BigFromString(d.Truncate(0).String())
+}
+
+//FormatTokenAmount 把最小单位的金额精确转换为带小数的字符串
+func FormatTokenAmount(amount BigInt, decimals int32) string {
+	if amount.Int == nil {
+		return "0"
+	}
+	return decimal.NewFromBigInt(amount.Int, -decimals).String()
+}
+
 func BigMul(a, b BigInt) BigInt {
 	return BigInt{Int: big.NewInt(0).Mul(a.Int, b.Int)}
 }
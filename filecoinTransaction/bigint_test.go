@@ -0,0 +1,117 @@
+package filecoinTransaction
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/filecoin-project/go-address"
+)
+
+func TestParseTokenAmount(t *testing.T) {
+	tests := []struct {
+		amount string
+		atto   string
+		err    bool
+	}{
+		{amount: "1", atto: "1000000000000000000"},
+		{amount: "0.000000000000000001", atto: "1"},
+		{amount: "9.223372036854775807", atto: "9223372036854775807"},
+		//2^63，超过 int64
+		{amount: "9.223372036854775808", atto: "9223372036854775808"},
+		{amount: "1999999999.999999999999999999", atto: "1999999999999999999999999999"},
+		{amount: "0.0000000000000000001", err: true},
+		{amount: "abc", err: true},
+	}
+
+	for _, tt := range tests {
+		v, err := ParseTokenAmount(tt.amount, 18)
+		if tt.err {
+			if err == nil {
+				t.Errorf("%s: expect error", tt.amount)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.amount, err)
+			continue
+		}
+		if v.String() != tt.atto {
+			t.Errorf("%s: expect %s, got %s", tt.amount, tt.atto, v.String())
+		}
+		if back := FormatTokenAmount(v, 18); back != tt.amount {
+			t.Errorf("%s: format back got %s", tt.amount, back)
+		}
+	}
+}
+
+func TestNewMessageWithFee_AboveInt64(t *testing.T) {
+	from, _ := address.NewFromString("f1lahsprwrm64xkyqm7psufpzlimmtefumnco4uaa")
+	to, _ := address.NewIDAddress(1024)
+
+	//2^63 + 1
+	overInt64, _ := new(big.Int).SetString("9223372036854775809", 10)
+	//2^64 * 1000
+	feeCap, _ := new(big.Int).SetString("18446744073709551616000", 10)
+
+	msg, err := NewMessageWithFee(from, to, 1,
+		BigInt{Int: overInt64},
+		BigInt{Int: big.NewInt(1000000)},
+		BigInt{Int: feeCap},
+		BigInt{Int: overInt64},
+		0, nil)
+	if err != nil {
+		t.Fatalf("NewMessageWithFee failed: %v", err)
+	}
+
+	data, err := msg.Serialize()
+	if err != nil {
+		t.Fatalf("Serialize failed: %v", err)
+	}
+	decoded, err := DecodeMessage(data)
+	if err != nil {
+		t.Fatalf("DecodeMessage failed: %v", err)
+	}
+	if decoded.Value.Int.Cmp(overInt64) != 0 {
+		t.Errorf("value changed: %s", decoded.Value)
+	}
+	if decoded.GasFeeCap.Int.Cmp(feeCap) != 0 {
+		t.Errorf("gas fee cap changed: %s", decoded.GasFeeCap)
+	}
+	if decoded.GasPremium.Int.Cmp(overInt64) != 0 {
+		t.Errorf("gas premium changed: %s", decoded.GasPremium)
+	}
+
+	//费用上限 = feeCap * gasLimit
+	expectFunds := new(big.Int).Mul(feeCap, big.NewInt(1000000))
+	if msg.RequiredFunds().Int.Cmp(expectFunds) != 0 {
+		t.Errorf("required funds expect %s, got %s", expectFunds, msg.RequiredFunds())
+	}
+
+	emptyTrans, _, err := msg.CreateEmptyTransactionAndMessage()
+	if err != nil {
+		t.Fatalf("CreateEmptyTransactionAndMessage failed: %v", err)
+	}
+	fromJSON, err := NewMessageFromJSON(emptyTrans)
+	if err != nil {
+		t.Fatalf("NewMessageFromJSON failed: %v", err)
+	}
+	if fromJSON.Cid() != msg.Cid() {
+		t.Errorf("json round trip changed the message")
+	}
+
+	//原始值不应被消息共享
+	overInt64.SetInt64(0)
+	if msg.Value.Int.Sign() == 0 {
+		t.Errorf("message should not share the caller's big.Int")
+	}
+}
+
+func TestNewMessageWithFee_GasLimitOverflow(t *testing.T) {
+	from, _ := address.NewFromString("f1lahsprwrm64xkyqm7psufpzlimmtefumnco4uaa")
+	to, _ := address.NewIDAddress(1024)
+	gasLimit, _ := new(big.Int).SetString("9223372036854775808", 10)
+
+	if _, err := NewMessageWithFee(from, to, 0, NewInt(1), BigInt{Int: gasLimit}, NewInt(1), NewInt(1), 0, nil); err == nil {
+		t.Errorf("gas limit above int64 should be rejected")
+	}
+}
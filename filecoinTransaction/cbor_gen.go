@@ -247,4 +247,65 @@ func (t *Message) UnmarshalCBOR(r io.Reader) error {
 		return err
 	}
 	return nil
-}
\ No newline at end of file
+}
+var lengthBufSignedMessage = []byte{130}
+
+func (t *SignedMessage) MarshalCBOR(w io.Writer) error {
+	if t == nil {
+		_, err := w.Write(cbg.CborNull)
+		return err
+	}
+	if _, err := w.Write(lengthBufSignedMessage); err != nil {
+		return err
+	}
+
+	// t.Message (filecoinTransaction.Message) (struct)
+	if err := t.Message.MarshalCBOR(w); err != nil {
+		return err
+	}
+
+	// t.Signature (filecoinTransaction.Signature) (struct)
+	if err := t.Signature.MarshalCBOR(w); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (t *SignedMessage) UnmarshalCBOR(r io.Reader) error {
+	*t = SignedMessage{}
+
+	br := cbg.GetPeeker(r)
+	scratch := make([]byte, 8)
+
+	maj, extra, err := cbg.CborReadHeaderBuf(br, scratch)
+	if err != nil {
+		return err
+	}
+	if maj != cbg.MajArray {
+		return fmt.Errorf("cbor input should be of type array")
+	}
+
+	if extra != 2 {
+		return fmt.Errorf("cbor input had wrong number of fields")
+	}
+
+	// t.Message (filecoinTransaction.Message) (struct)
+
+	{
+
+		if err := t.Message.UnmarshalCBOR(br); err != nil {
+			return xerrors.Errorf("unmarshaling t.Message: %w", err)
+		}
+
+	}
+	// t.Signature (filecoinTransaction.Signature) (struct)
+
+	{
+
+		if err := t.Signature.UnmarshalCBOR(br); err != nil {
+			return xerrors.Errorf("unmarshaling t.Signature: %w", err)
+		}
+
+	}
+	return nil
+}
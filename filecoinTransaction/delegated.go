@@ -0,0 +1,193 @@
+package filecoinTransaction
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/blocktree/go-owcrypt"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-crypto"
+	cbg "github.com/whyrusleeping/cbor-gen"
+)
+
+const (
+	SigTypeDelegated = byte(0x03) // 与 lotus crypto.SigTypeDelegated 一致
+
+	EthChainIDMainnet  = uint64(314)    // 主网 eth chain id
+	EthChainIDCalibnet = uint64(314159) // calibration 测试网 eth chain id
+
+	EthereumAddressSpace = uint64(10) // EAM actor 的ID，f410地址的命名空间
+	EthAddressLength     = 20
+
+	EIP1559TxType = byte(0x02)
+
+	//InvokeEVM方法号，f410地址发出的消息只能使用此方法
+	MethodInvokeEVM = uint64(3844450837)
+)
+
+//IsDelegatedAddress 是否为 f410 地址
+func IsDelegatedAddress(a address.Address) bool {
+	_, err := EthAddressFromFilecoinAddress(a)
+	return err == nil && a.Protocol() == address.Delegated
+}
+
+//EthAddressFromFilecoinAddress 把 f0/f410 地址转换为20字节的eth地址，f0地址转换为掩码ID地址 0xff0000...<id>
+func EthAddressFromFilecoinAddress(a address.Address) ([]byte, error) {
+	switch a.Protocol() {
+	case address.ID:
+		id, err := address.IDFromAddress(a)
+		if err != nil {
+			return nil, err
+		}
+		ethAddr := make([]byte, EthAddressLength)
+		ethAddr[0] = 0xff
+		binary.BigEndian.PutUint64(ethAddr[12:], id)
+		return ethAddr, nil
+	case address.Delegated:
+		payload := a.Payload()
+		namespace, n := binary.Uvarint(payload)
+		if n <= 0 {
+			return nil, errors.New("invalid delegated address namespace")
+		}
+		if namespace != EthereumAddressSpace {
+			return nil, fmt.Errorf("delegated address namespace %d is not the ethereum address space", namespace)
+		}
+		if len(payload[n:]) != EthAddressLength {
+			return nil, fmt.Errorf("f410 sub-address has %d bytes, expect %d", len(payload[n:]), EthAddressLength)
+		}
+		return payload[n:], nil
+	default:
+		return nil, fmt.Errorf("address %s can not be converted to an eth address, resolve it to an id address first", a)
+	}
+}
+
+//EthAddressFromPubKey 由secp256k1公钥计算eth地址，支持压缩与非压缩公钥
+func EthAddressFromPubKey(pub []byte) ([]byte, error) {
+	if len(pub) == 33 {
+		pub = owcrypt.PointDecompress(pub, owcrypt.ECC_CURVE_SECP256K1)
+	}
+	if len(pub) == 65 {
+		pub = pub[1:]
+	}
+	if len(pub) != 64 {
+		return nil, errors.New("invalid secp256k1 public key")
+	}
+	hash := owcrypt.Hash(pub, 32, owcrypt.HASH_ALG_KECCAK256)
+	return hash[12:], nil
+}
+
+//NewDelegatedAddressFromPubKey 由secp256k1公钥生成f410地址
+func NewDelegatedAddressFromPubKey(pub []byte) (address.Address, error) {
+	ethAddr, err := EthAddressFromPubKey(pub)
+	if err != nil {
+		return address.Undef, err
+	}
+	return address.NewDelegatedAddress(EthereumAddressSpace, ethAddr)
+}
+
+//EthUnsignedRLP 把f410地址发出的消息转换为 EIP-1559 交易的待签负载 0x02 || rlp(...)，与 lotus 的转换规则一致
+func (m *Message) EthUnsignedRLP(chainID uint64) ([]byte, error) {
+	if m.Version != MessageVersion {
+		return nil, fmt.Errorf("unsupported message version %d", m.Version)
+	}
+	if !IsDelegatedAddress(m.From) {
+		return nil, fmt.Errorf("sender %s is not an f410 address", m.From)
+	}
+	if uint64(m.Method) != MethodInvokeEVM {
+		return nil, fmt.Errorf("invalid method %d: messages from f410 addresses must use InvokeEVM(%d)", m.Method, MethodInvokeEVM)
+	}
+
+	to, err := EthAddressFromFilecoinAddress(m.To)
+	if err != nil {
+		return nil, err
+	}
+
+	input := []byte{}
+	if len(m.Params) > 0 {
+		input, err = cbg.ReadByteArray(bytes.NewReader(m.Params), uint64(len(m.Params)))
+		if err != nil {
+			return nil, fmt.Errorf("params of InvokeEVM must be a cbor byte string: %v", err)
+		}
+	}
+
+	fields := []interface{}{
+		chainID,
+		m.Nonce,
+		bigOrZero(m.GasPremium.Int),
+		bigOrZero(m.GasFeeCap.Int),
+		uint64(m.GasLimit),
+		to,
+		bigOrZero(m.Value.Int),
+		input,
+		[]interface{}{},
+	}
+	encoded, err := rlp.EncodeToBytes(fields)
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte{EIP1559TxType}, encoded...), nil
+}
+
+//DelegatedSigningHash f410地址的待签哈希 keccak256(0x02 || rlp(...))
+func (m *Message) DelegatedSigningHash(chainID uint64) ([]byte, error) {
+	payload, err := m.EthUnsignedRLP(chainID)
+	if err != nil {
+		return nil, err
+	}
+	return owcrypt.Hash(payload, 32, owcrypt.HASH_ALG_KECCAK256), nil
+}
+
+//CreateEmptyDelegatedTransactionAndMessage 返回空交易单与f410地址的待签哈希
+func (m Message) CreateEmptyDelegatedTransactionAndMessage(chainID uint64) (string, string, error) {
+	hash, err := m.DelegatedSigningHash(chainID)
+	if err != nil {
+		return "", "", err
+	}
+
+	js, _ := json.Marshal(m)
+
+	return string(js), hex.EncodeToString(hash), nil
+}
+
+//VerifyDelegatedSignature 校验f410地址的签名，签名为65字节 r||s||v
+func VerifyDelegatedSignature(m *Message, chainID uint64, sig []byte) error {
+	if len(sig) != Secp256k1SignatureBytes {
+		return fmt.Errorf("delegated signature has %d bytes, expect %d", len(sig), Secp256k1SignatureBytes)
+	}
+
+	hash, err := m.DelegatedSigningHash(chainID)
+	if err != nil {
+		return err
+	}
+
+	pubk, err := crypto.EcRecover(hash, sig)
+	if err != nil {
+		return fmt.Errorf("recover public key failed: %v", err)
+	}
+
+	ethAddr, err := EthAddressFromPubKey(pubk)
+	if err != nil {
+		return err
+	}
+	fromEthAddr, err := EthAddressFromFilecoinAddress(m.From)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(ethAddr, fromEthAddr) {
+		return errors.New("signature does not match sender")
+	}
+	return nil
+}
+
+func bigOrZero(b *big.Int) *big.Int {
+	if b == nil {
+		return big.NewInt(0)
+	}
+	return b
+}
@@ -0,0 +1,100 @@
+package filecoinTransaction
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/blocktree/go-owcrypt"
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/big"
+)
+
+func newDelegatedTestMessage(from address.Address) *Message {
+	to, _ := address.NewIDAddress(1111)
+	value := big.Mul(big.NewInt(12345), big.NewInt(1e18))
+	return &Message{
+		To:         to,
+		From:       from,
+		Nonce:      7,
+		Value:      value,
+		GasLimit:   1500000,
+		GasFeeCap:  abi.NewTokenAmount(200456),
+		GasPremium: abi.NewTokenAmount(100123),
+		Method:     abi.MethodNum(MethodInvokeEVM),
+	}
+}
+
+func TestEthAddressFromFilecoinAddress(t *testing.T) {
+	id, _ := address.NewIDAddress(1111)
+	ethAddr, err := EthAddressFromFilecoinAddress(id)
+	if err != nil {
+		t.Fatalf("convert id address failed: %v", err)
+	}
+	if hex.EncodeToString(ethAddr) != "ff00000000000000000000000000000000000457" {
+		t.Errorf("unexpected masked id address: %x", ethAddr)
+	}
+
+	f410, _ := address.NewFromString("f410fkkld55ioe7qg24wvt7fu6pbknb56ht7pt4zamxa")
+	ethAddr, err = EthAddressFromFilecoinAddress(f410)
+	if err != nil {
+		t.Fatalf("convert f410 address failed: %v", err)
+	}
+	if hex.EncodeToString(ethAddr) != "52963ef50e27e06d72d59fcb4f3c2a687be3cfef" {
+		t.Errorf("unexpected f410 sub-address: %x", ethAddr)
+	}
+
+	f1, _ := address.NewFromString("f1lahsprwrm64xkyqm7psufpzlimmtefumnco4uaa")
+	if _, err := EthAddressFromFilecoinAddress(f1); err == nil {
+		t.Errorf("f1 address should not convert to an eth address")
+	}
+}
+
+func TestDelegatedSigningHash(t *testing.T) {
+	from, _ := address.NewFromString("f410fkkld55ioe7qg24wvt7fu6pbknb56ht7pt4zamxa")
+	msg := newDelegatedTestMessage(from)
+
+	//与 go-ethereum LatestSignerForChainID(314).Hash(DynamicFeeTx) 的结果一致
+	hash, err := msg.DelegatedSigningHash(EthChainIDMainnet)
+	if err != nil {
+		t.Fatalf("DelegatedSigningHash failed: %v", err)
+	}
+	if hex.EncodeToString(hash) != "02af214cfd02802630c23d2bb8454e1b03afa98b597d652049095a4b87b9348d" {
+		t.Errorf("unexpected signing hash: %x", hash)
+	}
+
+	msg.Method = 0
+	if _, err := msg.DelegatedSigningHash(EthChainIDMainnet); err == nil {
+		t.Errorf("method send should be rejected for f410 senders")
+	}
+}
+
+func TestDelegatedSignAndVerify(t *testing.T) {
+	prikey, _ := hex.DecodeString("8bbba0e16e1fc2a4a0b1c6f3b1d2d2a5a0b1c6f3b1d2d2a5a0b1c6f3b1d2d2a5")
+	pub, retCode := owcrypt.GenPubkey(prikey, owcrypt.ECC_CURVE_SECP256K1)
+	if retCode != owcrypt.SUCCESS {
+		t.Fatalf("generate public key failed")
+	}
+	from, err := NewDelegatedAddressFromPubKey(pub)
+	if err != nil {
+		t.Fatalf("NewDelegatedAddressFromPubKey failed: %v", err)
+	}
+
+	msg := newDelegatedTestMessage(from)
+	emptyTrans, hash, err := msg.CreateEmptyDelegatedTransactionAndMessage(EthChainIDMainnet)
+	if err != nil {
+		t.Fatalf("CreateEmptyDelegatedTransactionAndMessage failed: %v", err)
+	}
+
+	sig, err := SignTransaction(hash, prikey)
+	if err != nil {
+		t.Fatalf("SignTransaction failed: %v", err)
+	}
+
+	if _, pass := VerifyAndCombineTransactionWithChainID(emptyTrans, hex.EncodeToString(sig), EthChainIDMainnet); !pass {
+		t.Errorf("delegated signature verify failed")
+	}
+	if _, pass := VerifyAndCombineTransactionWithChainID(emptyTrans, hex.EncodeToString(sig), EthChainIDCalibnet); pass {
+		t.Errorf("delegated signature should not verify under another chain id")
+	}
+}
@@ -0,0 +1,156 @@
+package filecoinTransaction
+
+import (
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/shopspring/decimal"
+)
+
+const (
+	FILDecimals     = int32(18) //1 FIL = 10^18 attoFIL
+	NanoFILDecimals = int32(9)  //1 nanoFIL = 10^9 attoFIL
+)
+
+//filUnits 单位后缀（小写）对应的精度，长后缀在前，避免 "fil" 先匹配 "nanofil"
+var filUnits = []struct {
+	suffix   string
+	decimals int32
+}{
+	{"attofil", 0},
+	{"nanofil", NanoFILDecimals},
+	{"fil", FILDecimals},
+}
+
+//FIL 金额类型，内部以 attoFIL 保存，不允许负数；零值为0
+//只能通过 NewFIL、ParseFIL 等函数创建，数值只读，保证金额经过检查
+//文本与JSON编码与 String() 一致，如 "1.5 FIL"，与 lotus types.FIL 相同
+type FIL struct {
+	atto *big.Int
+}
+
+//NewFIL 由 attoFIL 数值创建金额，会复制传入的值
+func NewFIL(atto *big.Int) (FIL, error) {
+	if atto == nil {
+		return FIL{}, nil
+	}
+	if atto.Sign() < 0 {
+		return FIL{}, fmt.Errorf("negative amount %s", atto)
+	}
+	return FIL{atto: new(big.Int).Set(atto)}, nil
+}
+
+//NewFILFromBigInt 由链上消息的 BigInt 创建金额
+func NewFILFromBigInt(atto BigInt) (FIL, error) {
+	return NewFIL(atto.Int)
+}
+
+//ParseAttoFIL 解析链上返回的 attoFIL 整数字符串
+func ParseAttoFIL(s string) (FIL, error) {
+	v, ok := new(big.Int).SetString(strings.TrimSpace(s), 10)
+	if !ok {
+		return FIL{}, fmt.Errorf("invalid attoFIL amount %q", s)
+	}
+	return NewFIL(v)
+}
+
+//ParseFIL 解析金额字符串，支持 FIL、nanoFIL、attoFIL 单位后缀（不区分大小写），无后缀时按 FIL 处理
+//超出单位精度的小数位与负数均返回错误
+func ParseFIL(s string) (FIL, error) {
+	str := strings.TrimSpace(s)
+	decimals := FILDecimals
+	lower := strings.ToLower(str)
+	for _, unit := range filUnits {
+		if strings.HasSuffix(lower, unit.suffix) {
+			str = strings.TrimSpace(str[:len(str)-len(unit.suffix)])
+			decimals = unit.decimals
+			break
+		}
+	}
+
+	amount, err := ParseTokenAmount(str, decimals)
+	if err != nil {
+		return FIL{}, fmt.Errorf("invalid FIL amount %q: %v", s, err)
+	}
+	if amount.Sign() < 0 {
+		return FIL{}, fmt.Errorf("negative FIL amount %q", s)
+	}
+	return FIL{atto: amount.Int}, nil
+}
+
+//AttoFIL 返回 attoFIL 数值的副本
+func (f FIL) AttoFIL() *big.Int {
+	if f.atto == nil {
+		return big.NewInt(0)
+	}
+	return new(big.Int).Set(f.atto)
+}
+
+//BigInt 返回链上消息使用的 BigInt
+func (f FIL) BigInt() BigInt {
+	return BigInt{Int: f.AttoFIL()}
+}
+
+//Decimal 以 FIL 为单位的 decimal 值
+func (f FIL) Decimal() decimal.Decimal {
+	return decimal.NewFromBigInt(f.AttoFIL(), -FILDecimals)
+}
+
+//Unitless 以 FIL 为单位、不带单位后缀的字符串，去掉末尾的0
+func (f FIL) Unitless() string {
+	return FormatTokenAmount(BigInt{Int: f.atto}, FILDecimals)
+}
+
+//String 以 FIL 为单位、带单位后缀的字符串
+func (f FIL) String() string {
+	return f.Unitless() + " FIL"
+}
+
+//Format 实现 fmt.Formatter，%s/%v 输出带单位的FIL，其他动词按 attoFIL 整数格式化
+func (f FIL) Format(s fmt.State, ch rune) {
+	switch ch {
+	case 's', 'v':
+		fmt.Fprint(s, f.String())
+	default:
+		f.AttoFIL().Format(s, ch)
+	}
+}
+
+//Add 两个金额相加
+func (f FIL) Add(o FIL) FIL {
+	return FIL{atto: new(big.Int).Add(f.AttoFIL(), o.AttoFIL())}
+}
+
+//Mul 单价乘以数量，用于 gasPrice*gas，数量不能为负数
+func (f FIL) Mul(n *big.Int) (FIL, error) {
+	if n == nil || n.Sign() < 0 {
+		return FIL{}, fmt.Errorf("invalid multiplier %v", n)
+	}
+	return FIL{atto: new(big.Int).Mul(f.AttoFIL(), n)}, nil
+}
+
+//Cmp 比较两个金额
+func (f FIL) Cmp(o FIL) int {
+	return f.AttoFIL().Cmp(o.AttoFIL())
+}
+
+//IsZero 金额是否为0
+func (f FIL) IsZero() bool {
+	return f.atto == nil || f.atto.Sign() == 0
+}
+
+//MarshalText 编码为带单位的FIL字符串
+func (f FIL) MarshalText() ([]byte, error) {
+	return []byte(f.String()), nil
+}
+
+//UnmarshalText 按 ParseFIL 解析，无单位后缀时按 FIL 处理
+func (f *FIL) UnmarshalText(text []byte) error {
+	v, err := ParseFIL(string(text))
+	if err != nil {
+		return err
+	}
+	*f = v
+	return nil
+}
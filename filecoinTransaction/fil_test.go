@@ -0,0 +1,115 @@
+package filecoinTransaction
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"testing"
+)
+
+func TestParseFIL(t *testing.T) {
+	tests := []struct {
+		amount string
+		atto   string
+		err    bool
+	}{
+		{amount: "1", atto: "1000000000000000000"},
+		{amount: "1.5 FIL", atto: "1500000000000000000"},
+		{amount: "1.5fil", atto: "1500000000000000000"},
+		{amount: "2 nanoFIL", atto: "2000000000"},
+		{amount: "0.000000001 nanoFIL", atto: "1"},
+		{amount: "12345 attoFIL", atto: "12345"},
+		{amount: "0.000000000000000001", atto: "1"},
+		//2^63，超过 int64
+		{amount: "9.223372036854775808", atto: "9223372036854775808"},
+		{amount: "0.0000000000000000001", err: true},
+		{amount: "0.0000000001 nanoFIL", err: true},
+		{amount: "1.5 attoFIL", err: true},
+		{amount: "-1", err: true},
+		{amount: "-1 attoFIL", err: true},
+		{amount: "1 mFIL", err: true},
+		{amount: "", err: true},
+	}
+
+	for _, tt := range tests {
+		v, err := ParseFIL(tt.amount)
+		if tt.err {
+			if err == nil {
+				t.Errorf("%q: expect error, got %s", tt.amount, v)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", tt.amount, err)
+			continue
+		}
+		if v.AttoFIL().String() != tt.atto {
+			t.Errorf("%q: expect %s, got %s", tt.amount, tt.atto, v.AttoFIL())
+		}
+
+		back, err := ParseFIL(v.String())
+		if err != nil || back.Cmp(v) != 0 {
+			t.Errorf("%q: format %q does not parse back", tt.amount, v.String())
+		}
+	}
+}
+
+func TestFILFormat(t *testing.T) {
+	v, err := ParseAttoFIL("1500000000000000000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.Unitless() != "1.5" || v.String() != "1.5 FIL" {
+		t.Errorf("unexpected format %q / %q", v.Unitless(), v.String())
+	}
+	if (FIL{}).Unitless() != "0" {
+		t.Errorf("zero value should format as 0")
+	}
+	if _, err := ParseAttoFIL("-1"); err == nil {
+		t.Errorf("negative attoFIL should be rejected")
+	}
+	if _, err := ParseAttoFIL("1.5"); err == nil {
+		t.Errorf("fractional attoFIL should be rejected")
+	}
+
+	if got := fmt.Sprintf("%v|%s|%d", v, v, v); got != "1.5 FIL|1.5 FIL|1500000000000000000" {
+		t.Errorf("unexpected fmt output %q", got)
+	}
+
+	fee, err := v.Mul(big.NewInt(3))
+	if err != nil || fee.Unitless() != "4.5" {
+		t.Errorf("unexpected product %s, err=%v", fee, err)
+	}
+	if _, err := v.Mul(big.NewInt(-1)); err == nil {
+		t.Errorf("negative multiplier should be rejected")
+	}
+}
+
+func TestFILJSON(t *testing.T) {
+	v, err := ParseFIL("1.5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := json.Marshal(struct{ Amount FIL }{v})
+	if err != nil || string(data) != `{"Amount":"1.5 FIL"}` {
+		t.Errorf("unexpected json %s, err=%v", data, err)
+	}
+
+	var decoded struct{ Amount FIL }
+	if err := json.Unmarshal(data, &decoded); err != nil || decoded.Amount.Cmp(v) != 0 {
+		t.Errorf("json does not parse back: %v", err)
+	}
+	if err := json.Unmarshal([]byte(`{"Amount":"-1 FIL"}`), &decoded); err == nil {
+		t.Errorf("negative amount should be rejected")
+	}
+}
+
+func TestGetBigIntAmountStrStrict(t *testing.T) {
+	if _, err := GetBigIntAmountStr("0.0000000000000000001", 18); err == nil {
+		t.Errorf("excess precision should be rejected")
+	}
+	v, err := GetBigIntAmountStr("1.35677", 18)
+	if err != nil || v != "1356770000000000000" {
+		t.Errorf("unexpected %s, err=%v", v, err)
+	}
+}
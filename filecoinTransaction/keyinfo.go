@@ -0,0 +1,108 @@
+package filecoinTransaction
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/blocktree/filecoin-adapter/filecoin_addrdec"
+	"github.com/blocktree/go-owcrypt"
+)
+
+const (
+	KTSecp256k1 = "secp256k1" // lotus wallet的secp256k1私钥类型
+	KTBLS       = "bls"       // lotus wallet的bls私钥类型
+
+	PrivateKeyBytes = 32
+)
+
+//KeyInfo lotus wallet export/import 使用的私钥格式，PrivateKey在JSON中为base64
+type KeyInfo struct {
+	Type       string
+	PrivateKey []byte
+}
+
+//ParseKeyInfo 解析 lotus wallet export 导出的hex字符串
+func ParseKeyInfo(exported string) (*KeyInfo, error) {
+	raw, err := hex.DecodeString(strings.TrimSpace(exported))
+	if err != nil {
+		return nil, fmt.Errorf("key info is not hex encoded: %v", err)
+	}
+
+	var ki KeyInfo
+	err = json.Unmarshal(raw, &ki)
+	if err != nil {
+		return nil, fmt.Errorf("key info is not valid json: %v", err)
+	}
+
+	if ki.Type != KTSecp256k1 && ki.Type != KTBLS {
+		return nil, fmt.Errorf("unsupported key type: %s", ki.Type)
+	}
+	if len(ki.PrivateKey) != PrivateKeyBytes {
+		return nil, fmt.Errorf("invalid private key length: %d", len(ki.PrivateKey))
+	}
+	return &ki, nil
+}
+
+//NewKeyInfo 用私钥构造KeyInfo，bls私钥按lotus约定为小端序
+func NewKeyInfo(keyType string, privateKey []byte) (*KeyInfo, error) {
+	if keyType != KTSecp256k1 && keyType != KTBLS {
+		return nil, fmt.Errorf("unsupported key type: %s", keyType)
+	}
+	if len(privateKey) != PrivateKeyBytes {
+		return nil, errors.New("invalid private key")
+	}
+	return &KeyInfo{Type: keyType, PrivateKey: append([]byte{}, privateKey...)}, nil
+}
+
+//Export 导出为 lotus wallet import 可用的hex字符串
+func (ki *KeyInfo) Export() (string, error) {
+	raw, err := json.Marshal(ki)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(raw), nil
+}
+
+//PublicKey 计算公钥，secp256k1为压缩公钥，bls为48字节G1公钥
+func (ki *KeyInfo) PublicKey() ([]byte, error) {
+	var (
+		pub []byte
+		ret uint16
+	)
+	switch ki.Type {
+	case KTSecp256k1:
+		pub, ret = owcrypt.GenPubkey(ki.PrivateKey, owcrypt.ECC_CURVE_SECP256K1)
+		if ret == owcrypt.SUCCESS {
+			pub = owcrypt.PointCompress(pub, owcrypt.ECC_CURVE_SECP256K1)
+		}
+	case KTBLS:
+		pub, ret = owcrypt.GenPubkey(blsPrivateKeyBE(ki.PrivateKey), owcrypt.ECC_CURVE_BLS12381_G2_XMD_SHA_256_SSWU_RO_NUL)
+	default:
+		return nil, fmt.Errorf("unsupported key type: %s", ki.Type)
+	}
+	if ret != owcrypt.SUCCESS {
+		return nil, errors.New("generate public key failed")
+	}
+	return pub, nil
+}
+
+//Address 用 AddressEncode 推导私钥对应的地址
+func (ki *KeyInfo) Address(isTestNet bool) (string, error) {
+	pub, err := ki.PublicKey()
+	if err != nil {
+		return "", err
+	}
+	return filecoin_addrdec.NewAddressDecoderV2(isTestNet).AddressEncode(pub)
+}
+
+//blsPrivateKeyBE lotus的bls私钥为小端序，owcrypt需要大端序
+func blsPrivateKeyBE(priv []byte) []byte {
+	be := make([]byte, len(priv))
+	for i := range priv {
+		be[len(priv)-1-i] = priv[i]
+	}
+	return be
+}
@@ -0,0 +1,81 @@
+package filecoinTransaction
+
+import (
+	"encoding/hex"
+	"testing"
+)
+
+//lotus wallet export 的输出为 hex(json.Marshal(types.KeyInfo))，地址按 lotus 的实现推导：
+//secp256k1 用 go-crypto 计算非压缩公钥，bls 用 blst 按小端序读取私钥
+func TestKeyInfo_ImportExport(t *testing.T) {
+	tests := []struct {
+		keyType    string
+		privateKey string
+		exported   string
+		address    string
+	}{
+		{
+			keyType:    KTSecp256k1,
+			privateKey: "8bcba0e16e1fc2a4a0b1c6f3b1d2d2a5a0b1c6f3b1d2d2a5a0b1c6f3b1d2d2a5",
+			exported:   "7b2254797065223a22736563703235366b31222c22507269766174654b6579223a226938756734573466777153677363627a73644c537061437878764f7830744b6c6f4c4847383748533071553d227d",
+			address:    "f1lahsprwrm64xkyqm7psufpzlimmtefumnco4uaa",
+		},
+		{
+			keyType:    KTBLS,
+			privateKey: "0d3d4b0e2a6a9c1f5f6b7c8d9e0f1a2b3c4d5e6f708192a3b4c5d6e7f8091a12",
+			exported:   "7b2254797065223a22626c73222c22507269766174654b6579223a224454314c446970716e4239666133794e6e6738614b7a784e586d3977675a4b6a744d5857352f674a4768493d227d",
+			address:    "f3swtawkooybc4zvbl7dglx2xvc4537iqwuafhqsw56hdwny47lpuqgflhdjm7e44mthmxain77k7wpvlur5hq",
+		},
+	}
+
+	for _, tt := range tests {
+		ki, err := ParseKeyInfo(tt.exported)
+		if err != nil {
+			t.Errorf("ParseKeyInfo failed: %v", err)
+			continue
+		}
+		if ki.Type != tt.keyType {
+			t.Errorf("expect key type %s, got %s", tt.keyType, ki.Type)
+		}
+		if hex.EncodeToString(ki.PrivateKey) != tt.privateKey {
+			t.Errorf("%s imported private key mismatch", tt.keyType)
+		}
+
+		addr, err := ki.Address(false)
+		if err != nil {
+			t.Errorf("derive address failed: %v", err)
+			continue
+		}
+		if addr != tt.address {
+			t.Errorf("expect address %s, got %s", tt.address, addr)
+		}
+
+		priv, _ := hex.DecodeString(tt.privateKey)
+		created, err := NewKeyInfo(tt.keyType, priv)
+		if err != nil {
+			t.Errorf("NewKeyInfo failed: %v", err)
+			continue
+		}
+		exported, err := created.Export()
+		if err != nil {
+			t.Errorf("Export failed: %v", err)
+			continue
+		}
+		if exported != tt.exported {
+			t.Errorf("%s export does not match lotus: %s", tt.keyType, exported)
+		}
+	}
+}
+
+func TestKeyInfo_ParseInvalid(t *testing.T) {
+	invalid := []string{
+		"zz",
+		hex.EncodeToString([]byte(`{"Type":"secp256k1","PrivateKey":"AAEC"}`)),
+		hex.EncodeToString([]byte(`{"Type":"ed25519","PrivateKey":"iLug4W4fwqSgscbzsdLSpaCxxvOx0tKloLHG87HS0qU="}`)),
+	}
+	for _, exported := range invalid {
+		if _, err := ParseKeyInfo(exported); err == nil {
+			t.Errorf("expect error for %s", exported)
+		}
+	}
+}
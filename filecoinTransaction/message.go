@@ -42,6 +42,32 @@ type Message struct {
 	Params []byte
 }
 
+//NewMessageWithFee 构建消息，金额与手续费全程使用大整数，不经过 int64 转换
+func NewMessageWithFee(from, to address.Address, nonce uint64, value, gasLimit, gasFeeCap, gasPremium BigInt, method uint64, params []byte) (*Message, error) {
+	if value.Int == nil || gasLimit.Int == nil || gasFeeCap.Int == nil || gasPremium.Int == nil {
+		return nil, xerrors.New("value, gas limit, gas fee cap and gas premium are required")
+	}
+	if !gasLimit.IsInt64() || gasLimit.Sign() <= 0 {
+		return nil, xerrors.Errorf("gas limit %s is out of range", gasLimit)
+	}
+
+	msg := &Message{
+		To:         to,
+		From:       from,
+		Nonce:      nonce,
+		Value:      value.Copy(),
+		GasLimit:   gasLimit.Int64(),
+		GasFeeCap:  gasFeeCap.Copy(),
+		GasPremium: gasPremium.Copy(),
+		Method:     abi.MethodNum(method),
+		Params:     params,
+	}
+	if err := msg.ValidForBlockInclusion(0); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
 func (m *Message) Caller() address.Address {
 	return m.From
 }
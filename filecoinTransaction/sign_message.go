@@ -0,0 +1,89 @@
+package filecoinTransaction
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/blocktree/go-owcrypt"
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-crypto"
+	"github.com/minio/blake2b-simd"
+)
+
+const (
+	SigTypeSecp256k1 = byte(0x01) // 与 lotus crypto.SigTypeSecp256k1 一致
+	SigTypeBLS       = byte(0x02) // 与 lotus crypto.SigTypeBLS 一致
+
+	Secp256k1SignatureBytes = 65
+	BLSSignatureBytes       = 96
+)
+
+//SignMessage 按 lotus wallet sign 的约定签名任意数据，返回 类型字节+签名
+//secp256k1 对 blake2b-256(data) 签名，bls 直接对 data 签名
+func SignMessage(ki *KeyInfo, data []byte) ([]byte, error) {
+	if len(data) == 0 {
+		return nil, errors.New("data to sign is empty")
+	}
+
+	switch ki.Type {
+	case KTSecp256k1:
+		b2sum := blake2b.Sum256(data)
+		//与 lotus 相同使用 go-crypto 签名，RFC6979 确定性随机数，相同数据的签名一致
+		signature, err := crypto.Sign(ki.PrivateKey, b2sum[:])
+		if err != nil {
+			return nil, fmt.Errorf("sign failed: %v", err)
+		}
+		return append([]byte{SigTypeSecp256k1}, signature...), nil
+	case KTBLS:
+		signature, _, retCode := owcrypt.Signature(blsPrivateKeyBE(ki.PrivateKey), nil, data, owcrypt.ECC_CURVE_BLS12381_G2_XMD_SHA_256_SSWU_RO_NUL)
+		if retCode != owcrypt.SUCCESS {
+			return nil, errors.New("sign failed")
+		}
+		return append([]byte{SigTypeBLS}, signature...), nil
+	default:
+		return nil, fmt.Errorf("unsupported key type: %s", ki.Type)
+	}
+}
+
+//VerifyMessage 按 lotus wallet verify 的约定校验 类型字节+签名 是否由地址签出
+func VerifyMessage(addr string, data []byte, signature []byte) error {
+	a, err := address.NewFromString(addr)
+	if err != nil {
+		return fmt.Errorf("invalid address: %v", err)
+	}
+	if len(signature) == 0 {
+		return errors.New("signature is empty")
+	}
+
+	sigType, sig := signature[0], signature[1:]
+
+	switch a.Protocol() {
+	case address.SECP256K1:
+		if sigType != SigTypeSecp256k1 || len(sig) != Secp256k1SignatureBytes {
+			return errors.New("signature is not a secp256k1 signature")
+		}
+		b2sum := blake2b.Sum256(data)
+		pubk, err := crypto.EcRecover(b2sum[:], sig)
+		if err != nil {
+			return fmt.Errorf("recover public key failed: %v", err)
+		}
+		maybeaddr, err := address.NewSecp256k1Address(pubk)
+		if err != nil {
+			return err
+		}
+		if maybeaddr != a {
+			return errors.New("signature does not match address")
+		}
+		return nil
+	case address.BLS:
+		if sigType != SigTypeBLS || len(sig) != BLSSignatureBytes {
+			return errors.New("signature is not a bls signature")
+		}
+		if owcrypt.Verify(a.Payload(), nil, data, sig, owcrypt.ECC_CURVE_BLS12381_G2_XMD_SHA_256_SSWU_RO_NUL) != owcrypt.SUCCESS {
+			return errors.New("signature does not match address")
+		}
+		return nil
+	default:
+		return fmt.Errorf("address protocol %d can not sign", a.Protocol())
+	}
+}
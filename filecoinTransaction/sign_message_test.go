@@ -0,0 +1,101 @@
+package filecoinTransaction
+
+import (
+	"encoding/hex"
+	"testing"
+)
+
+func TestSignMessage_Verify(t *testing.T) {
+	exported := []string{
+		"7b2254797065223a22736563703235366b31222c22507269766174654b6579223a226938756734573466777153677363627a73644c537061437878764f7830744b6c6f4c4847383748533071553d227d",
+		"7b2254797065223a22626c73222c22507269766174654b6579223a224454314c446970716e4239666133794e6e6738614b7a784e586d3977675a4b6a744d5857352f674a4768493d227d",
+	}
+	data := []byte("prove ownership of this address")
+
+	for _, e := range exported {
+		ki, err := ParseKeyInfo(e)
+		if err != nil {
+			t.Fatalf("ParseKeyInfo failed: %v", err)
+		}
+		addr, err := ki.Address(false)
+		if err != nil {
+			t.Fatalf("derive address failed: %v", err)
+		}
+
+		sig, err := SignMessage(ki, data)
+		if err != nil {
+			t.Errorf("%s sign failed: %v", ki.Type, err)
+			continue
+		}
+		t.Logf("%s signature: %s", addr, hex.EncodeToString(sig))
+
+		if err := VerifyMessage(addr, data, sig); err != nil {
+			t.Errorf("%s verify failed: %v", ki.Type, err)
+		}
+		if err := VerifyMessage(addr, []byte("tampered"), sig); err == nil {
+			t.Errorf("%s verify should fail on tampered data", ki.Type)
+		}
+		sig[0] ^= 0x03
+		if err := VerifyMessage(addr, data, sig); err == nil {
+			t.Errorf("%s verify should fail on wrong signature type", ki.Type)
+		}
+	}
+}
+
+func TestVerifyMessage_WrongAddress(t *testing.T) {
+	ki, err := ParseKeyInfo("7b2254797065223a22736563703235366b31222c22507269766174654b6579223a226938756734573466777153677363627a73644c537061437878764f7830744b6c6f4c4847383748533071553d227d")
+	if err != nil {
+		t.Fatalf("ParseKeyInfo failed: %v", err)
+	}
+	data := []byte("hello")
+	sig, err := SignMessage(ki, data)
+	if err != nil {
+		t.Fatalf("sign failed: %v", err)
+	}
+	if err := VerifyMessage("f1abjxfbp274xpdqcpuaykwkfb43omjotacm2p3za", data, sig); err == nil {
+		t.Errorf("verify should fail for another address")
+	}
+	if err := VerifyMessage("f01234", data, sig); err == nil {
+		t.Errorf("verify should fail for id address")
+	}
+}
+
+//lotus wallet sign 的输出为 hex(类型字节+签名)，secp256k1 与 bls 签名都是确定的，需逐字节一致
+func TestSignMessage_LotusWalletSign(t *testing.T) {
+	tests := []struct {
+		exported  string
+		address   string
+		signature string
+	}{
+		{
+			exported:  "7b2254797065223a22736563703235366b31222c22507269766174654b6579223a226938756734573466777153677363627a73644c537061437878764f7830744b6c6f4c4847383748533071553d227d",
+			address:   "f1lahsprwrm64xkyqm7psufpzlimmtefumnco4uaa",
+			signature: "018eb21073857aee5eebab9ff5692ed8de55eaf19267ba7347c242dc6464afb44026556a43b5d5cacdf50f1d328b7f8e615f1d2f10021ec199745e611ec206c40300",
+		},
+		{
+			exported:  "7b2254797065223a22626c73222c22507269766174654b6579223a224454314c446970716e4239666133794e6e6738614b7a784e586d3977675a4b6a744d5857352f674a4768493d227d",
+			address:   "f3swtawkooybc4zvbl7dglx2xvc4537iqwuafhqsw56hdwny47lpuqgflhdjm7e44mthmxain77k7wpvlur5hq",
+			signature: "0283e3e4fad6052d46109276c0c70e1ff8cf5fb626939682d81f348f3440613d1f2d39892d3e50efe131b6e0d203e1597f121d1ae392bf76b733bf9259060c93ebe37e3ebce5b1f1f28a91443b5bdc30397f6a5f965139df8fe8f6310b3803c61a",
+		},
+	}
+	data := []byte("prove ownership of this address")
+
+	for _, tt := range tests {
+		expected, _ := hex.DecodeString(tt.signature)
+		if err := VerifyMessage(tt.address, data, expected); err != nil {
+			t.Errorf("%s verify lotus signature failed: %v", tt.address, err)
+		}
+
+		ki, err := ParseKeyInfo(tt.exported)
+		if err != nil {
+			t.Fatalf("ParseKeyInfo failed: %v", err)
+		}
+		sig, err := SignMessage(ki, data)
+		if err != nil {
+			t.Fatalf("%s sign failed: %v", ki.Type, err)
+		}
+		if hex.EncodeToString(sig) != tt.signature {
+			t.Errorf("%s signature does not match lotus: %s", ki.Type, hex.EncodeToString(sig))
+		}
+	}
+}
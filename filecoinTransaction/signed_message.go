@@ -0,0 +1,153 @@
+package filecoinTransaction
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	block "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	cbg "github.com/whyrusleeping/cbor-gen"
+)
+
+const SignatureMaxLength = 200
+
+//Signature 消息签名，CBOR编码为 类型字节+签名 的字节串
+type Signature struct {
+	Type byte
+	Data []byte
+}
+
+//SignatureTypeForAddress 按发送地址的协议返回签名类型
+func SignatureTypeForAddress(from address.Address) (byte, error) {
+	switch from.Protocol() {
+	case address.SECP256K1:
+		return SigTypeSecp256k1, nil
+	case address.BLS:
+		return SigTypeBLS, nil
+	case address.Delegated:
+		return SigTypeDelegated, nil
+	default:
+		return 0, fmt.Errorf("address %s can not sign messages", from)
+	}
+}
+
+func (s *Signature) MarshalCBOR(w io.Writer) error {
+	if s == nil {
+		_, err := w.Write(cbg.CborNull)
+		return err
+	}
+
+	header := cbg.CborEncodeMajorType(cbg.MajByteString, uint64(len(s.Data)+1))
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+	if _, err := w.Write([]byte{s.Type}); err != nil {
+		return err
+	}
+	if _, err := w.Write(s.Data); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (s *Signature) UnmarshalCBOR(br io.Reader) error {
+	maj, l, err := cbg.CborReadHeader(br)
+	if err != nil {
+		return err
+	}
+
+	if maj != cbg.MajByteString {
+		return fmt.Errorf("not a byte string")
+	}
+	if l > SignatureMaxLength {
+		return fmt.Errorf("string too long")
+	}
+	if l == 0 {
+		return fmt.Errorf("string empty")
+	}
+	buf := make([]byte, l)
+	if _, err = io.ReadFull(br, buf); err != nil {
+		return err
+	}
+	switch buf[0] {
+	case SigTypeSecp256k1, SigTypeBLS, SigTypeDelegated:
+		s.Type = buf[0]
+	default:
+		return fmt.Errorf("invalid signature type in cbor input: %d", buf[0])
+	}
+	s.Data = buf[1:]
+	return nil
+}
+
+//SignedMessage 已签名消息，与 lotus types.SignedMessage 编码一致
+type SignedMessage struct {
+	Message   Message
+	Signature Signature
+}
+
+//NewSignedMessage 按发送地址的协议组装已签名消息
+func NewSignedMessage(msg *Message, sig []byte) (*SignedMessage, error) {
+	sigType, err := SignatureTypeForAddress(msg.From)
+	if err != nil {
+		return nil, err
+	}
+	return &SignedMessage{
+		Message: *msg,
+		Signature: Signature{
+			Type: sigType,
+			Data: sig,
+		},
+	}, nil
+}
+
+func DecodeSignedMessage(data []byte) (*SignedMessage, error) {
+	var msg SignedMessage
+	if err := msg.UnmarshalCBOR(bytes.NewReader(data)); err != nil {
+		return nil, err
+	}
+
+	return &msg, nil
+}
+
+func (sm *SignedMessage) Serialize() ([]byte, error) {
+	buf := new(bytes.Buffer)
+	if err := sm.MarshalCBOR(buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (sm *SignedMessage) ToStorageBlock() (block.Block, error) {
+	if sm.Signature.Type == SigTypeBLS {
+		return sm.Message.ToStorageBlock()
+	}
+
+	data, err := sm.Serialize()
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := abi.CidBuilder.Sum(data)
+	if err != nil {
+		return nil, err
+	}
+
+	return block.NewBlockWithCid(data, c)
+}
+
+//Cid 消息在链上的CID，bls消息为未签名消息的CID，其余为已签名消息的CID
+func (sm *SignedMessage) Cid() (cid.Cid, error) {
+	sb, err := sm.ToStorageBlock()
+	if err != nil {
+		return cid.Undef, fmt.Errorf("failed to marshal signed message: %v", err)
+	}
+
+	return sb.Cid(), nil
+}
+
+func (sm *SignedMessage) VMMessage() *Message {
+	return &sm.Message
+}
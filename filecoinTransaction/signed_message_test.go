@@ -0,0 +1,143 @@
+package filecoinTransaction
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+)
+
+func newSignedTestMessage(t *testing.T, from string) *Message {
+	fromAddr, err := address.NewFromString(from)
+	if err != nil {
+		t.Fatalf("invalid from address: %v", err)
+	}
+	toAddr, _ := address.NewIDAddress(1024)
+	return &Message{
+		To:         toAddr,
+		From:       fromAddr,
+		Nonce:      3,
+		Value:      abi.NewTokenAmount(1000),
+		GasLimit:   600000,
+		GasFeeCap:  abi.NewTokenAmount(200000),
+		GasPremium: abi.NewTokenAmount(100000),
+	}
+}
+
+func signedMessageCid(t *testing.T, sm *SignedMessage) string {
+	c, err := sm.Cid()
+	if err != nil {
+		t.Fatalf("signed message cid failed: %v", err)
+	}
+	return c.String()
+}
+
+func TestSignedMessage_CBORRoundTrip(t *testing.T) {
+	msg := newSignedTestMessage(t, "f1lahsprwrm64xkyqm7psufpzlimmtefumnco4uaa")
+	sm, err := NewSignedMessage(msg, bytes.Repeat([]byte{0x01}, Secp256k1SignatureBytes))
+	if err != nil {
+		t.Fatalf("NewSignedMessage failed: %v", err)
+	}
+	if sm.Signature.Type != SigTypeSecp256k1 {
+		t.Errorf("expect secp256k1 signature type, got %d", sm.Signature.Type)
+	}
+
+	data, err := sm.Serialize()
+	if err != nil {
+		t.Fatalf("Serialize failed: %v", err)
+	}
+	decoded, err := DecodeSignedMessage(data)
+	if err != nil {
+		t.Fatalf("DecodeSignedMessage failed: %v", err)
+	}
+	if signedMessageCid(t, decoded) != signedMessageCid(t, sm) {
+		t.Errorf("cid changed after round trip: %s != %s", signedMessageCid(t, decoded), signedMessageCid(t, sm))
+	}
+	if !bytes.Equal(decoded.Signature.Data, sm.Signature.Data) {
+		t.Errorf("signature changed after round trip")
+	}
+}
+
+func TestSignedMessage_Cid(t *testing.T) {
+	secp := newSignedTestMessage(t, "f1lahsprwrm64xkyqm7psufpzlimmtefumnco4uaa")
+	sm, _ := NewSignedMessage(secp, bytes.Repeat([]byte{0x01}, Secp256k1SignatureBytes))
+	if signedMessageCid(t, sm) == secp.Cid().String() {
+		t.Errorf("secp256k1 message cid should be the signed cid")
+	}
+
+	bls := newSignedTestMessage(t, "f3swtawkooybc4zvbl7dglx2xvc4537iqwuafhqsw56hdwny47lpuqgflhdjm7e44mthmxain77k7wpvlur5hq")
+	sm, _ = NewSignedMessage(bls, bytes.Repeat([]byte{0x02}, BLSSignatureBytes))
+	if sm.Signature.Type != SigTypeBLS {
+		t.Errorf("expect bls signature type, got %d", sm.Signature.Type)
+	}
+	if signedMessageCid(t, sm) != bls.Cid().String() {
+		t.Errorf("bls message cid should be the unsigned cid")
+	}
+
+	delegated := newSignedTestMessage(t, "f410fkkld55ioe7qg24wvt7fu6pbknb56ht7pt4zamxa")
+	sm, _ = NewSignedMessage(delegated, bytes.Repeat([]byte{0x03}, Secp256k1SignatureBytes))
+	if sm.Signature.Type != SigTypeDelegated {
+		t.Errorf("expect delegated signature type, got %d", sm.Signature.Type)
+	}
+	if signedMessageCid(t, sm) == delegated.Cid().String() {
+		t.Errorf("delegated message cid should be the signed cid")
+	}
+}
+
+func TestSignature_UnmarshalInvalidType(t *testing.T) {
+	var sig Signature
+	if err := sig.UnmarshalCBOR(bytes.NewReader([]byte{0x42, 0x07, 0x00})); err == nil {
+		t.Errorf("expect error for unknown signature type")
+	}
+}
+
+//期望的编码与CID按 lotus types.SignedMessage 的实现计算，签名为对未签名消息CID的真实签名
+func TestSignedMessage_LotusVectors(t *testing.T) {
+	tests := []struct {
+		from       string
+		signature  string
+		serialized string
+		cid        string
+	}{
+		{
+			from:       "f1lahsprwrm64xkyqm7psufpzlimmtefumnco4uaa",
+			signature:  "db6930d8a74da95f968cd780ec8a271001c4bc7614a5d81c0859295e4af0d5f761ab777a90dfbe547a968f12d88594a3e67b225df07ed328cc9aef7294400eee00",
+			serialized: "828a00430080085501580f27c6d167b975620cfbe542bf2b431932168c03430003e81a000927c04400030d4044000186a00040584201db6930d8a74da95f968cd780ec8a271001c4bc7614a5d81c0859295e4af0d5f761ab777a90dfbe547a968f12d88594a3e67b225df07ed328cc9aef7294400eee00",
+			cid:        "bafy2bzacebae266xtv7wxpmww2blhlo2pfvetfc3ja6uho6uduvmakrcvebkw",
+		},
+		{
+			from:       "f3swtawkooybc4zvbl7dglx2xvc4537iqwuafhqsw56hdwny47lpuqgflhdjm7e44mthmxain77k7wpvlur5hq",
+			signature:  "85c2de4a0f0632f3fdfb174ff7a3830cf72e8cca92eaaa8493678cda964dfb545c9afaa48ade45262378f259fd78de4f08c5cb17818f57edd87b1dac8bf3230b4d664b0062776aa2c86ea659619260b7c78b900541d446810425e95b61ecd689",
+			serialized: "828a004300800858310395a60b29cec045ccd42bf8ccbbeaf5173bbfa216a00a784addf1c766e39f5be90315671a59f2738c99d97021bffabf6703430003e81a000927c04400030d4044000186a0004058610285c2de4a0f0632f3fdfb174ff7a3830cf72e8cca92eaaa8493678cda964dfb545c9afaa48ade45262378f259fd78de4f08c5cb17818f57edd87b1dac8bf3230b4d664b0062776aa2c86ea659619260b7c78b900541d446810425e95b61ecd689",
+			cid:        "bafy2bzacecjkbv2hawz7uweoi7wqflnfgd2cu72a7zlorkyqzbg2ndqojfyfo",
+		},
+	}
+
+	for _, tt := range tests {
+		sig, _ := hex.DecodeString(tt.signature)
+		sm, err := NewSignedMessage(newSignedTestMessage(t, tt.from), sig)
+		if err != nil {
+			t.Fatalf("NewSignedMessage failed: %v", err)
+		}
+		data, err := sm.Serialize()
+		if err != nil {
+			t.Fatalf("Serialize failed: %v", err)
+		}
+		if hex.EncodeToString(data) != tt.serialized {
+			t.Errorf("%s serialized message does not match lotus: %x", tt.from, data)
+		}
+		if c := signedMessageCid(t, sm); c != tt.cid {
+			t.Errorf("%s expect cid %s, got %s", tt.from, tt.cid, c)
+		}
+
+		decoded, err := DecodeSignedMessage(data)
+		if err != nil {
+			t.Fatalf("DecodeSignedMessage failed: %v", err)
+		}
+		if c := signedMessageCid(t, decoded); c != tt.cid {
+			t.Errorf("%s decoded cid %s, expect %s", tt.from, c, tt.cid)
+		}
+	}
+}
@@ -6,11 +6,9 @@ import (
 	"errors"
 	"fmt"
 	"github.com/blocktree/go-owcrypt"
-	"github.com/ethereum/go-ethereum/common/math"
 	"github.com/filecoin-project/go-address"
 	"github.com/filecoin-project/go-crypto"
 	"github.com/minio/blake2b-simd"
-	"github.com/shopspring/decimal"
 )
 
 func (m Message) CreateEmptyTransactionAndMessage() (string, string, error) {
@@ -25,6 +23,15 @@ func (m Message) CreateEmptyTransactionAndMessage() (string, string, error) {
 	return string(js), hex.EncodeToString(b2sum[:]), nil
 }
 
+//SigningHash 消息的待签哈希，f410地址为EIP-1559交易哈希，其余为 blake2b-256(CID)
+func (m *Message) SigningHash(chainID uint64) ([]byte, error) {
+	if m.From.Protocol() == address.Delegated {
+		return m.DelegatedSigningHash(chainID)
+	}
+	b2sum := blake2b.Sum256(m.Cid().Bytes())
+	return b2sum[:], nil
+}
+
 func NewMessageFromJSON(j string) (*Message, error) {
 
 	m := Message{}
@@ -39,7 +46,6 @@ func NewMessageFromJSON(j string) (*Message, error) {
 }
 
 func SignTransaction(msgStr string, prikey []byte) ([]byte, error) {
-	fmt.Println("msg :", msgStr, ", prikey : ", hex.EncodeToString(prikey) )
 	msg, err := hex.DecodeString(msgStr)
 	if err != nil || len(msg) == 0 {
 		return nil, errors.New("invalid message to sign")
@@ -60,11 +66,24 @@ func SignTransaction(msgStr string, prikey []byte) ([]byte, error) {
 }
 
 func VerifyAndCombineTransaction(emptyTrans, signature string) (string, bool) {
+	return VerifyAndCombineTransactionWithChainID(emptyTrans, signature, EthChainIDMainnet)
+}
+
+//VerifyAndCombineTransactionWithChainID 校验签名，f410地址按 chainID 校验 delegated 签名
+func VerifyAndCombineTransactionWithChainID(emptyTrans, signature string, chainID uint64) (string, bool) {
 	message, err := NewMessageFromJSON(emptyTrans)
 	if err != nil {
 		return "", false
 	}
 
+	if message.From.Protocol() == address.Delegated {
+		sig, _ := hex.DecodeString(signature)
+		if err := VerifyDelegatedSignature(message, chainID, sig); err != nil {
+			return "", false
+		}
+		return hex.EncodeToString(message.Cid().Bytes()), true
+	}
+
 	//js, _ := json.Marshal(message)
 	//fmt.Println("js : ", string(js))
 
@@ -90,18 +109,11 @@ func VerifyAndCombineTransaction(emptyTrans, signature string) (string, bool) {
 	return hex.EncodeToString(bytes), true
 }
 
+//GetBigIntAmountStr 把金额转为最小单位的字符串，超出精度时返回错误
 func GetBigIntAmountStr(amountStr string, amountDecimal int32) (string, error){
-	d, err := decimal.NewFromString(amountStr)
-	if err!= nil {
-		return "", err
-	}
-	//atomDec := amountDec.Mul( decimal.)
-	ten := math.BigPow(10, int64(amountDecimal) )
-	w, err := decimal.NewFromString( ten.String() )
-	if err!= nil {
+	amount, err := ParseTokenAmount(amountStr, amountDecimal)
+	if err != nil {
 		return "", err
 	}
-
-	d = d.Mul(w)
-	return d.String(), nil
+	return amount.String(), nil
 }
\ No newline at end of file
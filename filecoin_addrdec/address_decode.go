@@ -51,8 +51,14 @@ func (dec *AddressDecoderV2) AddressDecode(addr string, opts ...interface{}) ([]
 
 }
 
-//AddressEncode 地址编码
+//AddressEncode 地址编码，48字节的bls公钥编码为f3地址，其余按secp256k1公钥编码为f1地址
 func (dec *AddressDecoderV2) AddressEncode(publicKey []byte, opts ...interface{}) (string, error) {
+	if len(publicKey) == BlsPublicKeyBytes {
+		cksm := owcrypt.Hash(append([]byte{Bls_Protocol}, publicKey...), ChecksumHashLength, owcrypt.HASH_ALG_BLAKE2B)
+		payload := append(append([]byte{}, publicKey...), cksm...)
+		return dec.GetNtwk() + fmt.Sprintf("%d", Bls_Protocol) + addressEncoding.WithPadding(-1).EncodeToString(payload), nil
+	}
+
 	if len(publicKey) != 32 {
 		//公钥hash处理
 		publicKey = owcrypt.PointDecompress(publicKey, owcrypt.ECC_CURVE_SECP256K1)
@@ -66,45 +72,20 @@ func (dec *AddressDecoderV2) AddressEncode(publicKey []byte, opts ...interface{}
 	return address, nil
 }
 
-// AddressVerify 地址校验
+// AddressVerify 地址校验，只接受可作为钱包地址的secp256k1、bls与f410地址
 func (dec *AddressDecoderV2) AddressVerify(address string, opts ...interface{}) bool {
-
-	if len(address)==41 && strings.HasPrefix(address, dec.GetNtwk()+"1"){
-
-	}else if len(address)==86 && strings.HasPrefix(address, dec.GetNtwk()+"3"){
-
-	}else{
-		return false
-	}
-
-	prefix := address[:2]
-
-	if prefix != dec.GetNtwk()+fmt.Sprintf("%d", Secp256k1_Protocol) && prefix != dec.GetNtwk()+fmt.Sprintf("%d", Bls_Protocol)  {
+	info, err := dec.ValidateAddress(address)
+	if err != nil {
 		return false
 	}
-
-	protocol := Secp256k1_Protocol
-	payLoadHashLength := PayloadHashLength
-	if prefix == dec.GetNtwk()+fmt.Sprintf("%d", Bls_Protocol) {
-		protocol = Bls_Protocol
-		payLoadHashLength = BlsPublicKeyBytes
-	}
-
-	addressEnd, _ := addressEncoding.WithPadding(-1).DecodeString( address[2:] )
-
-	decodeBytes := append([]byte{protocol}, addressEnd[:payLoadHashLength]...)
-	check := owcrypt.Hash(decodeBytes, ChecksumHashLength, owcrypt.HASH_ALG_BLAKE2B)
-
-	if len(addressEnd)<24 || len(check)<4 {
+	switch info.Protocol {
+	case Secp256k1_Protocol, Bls_Protocol:
+		return true
+	case Delegated_Protocol:
+		return len(info.Payload) == 1+EthereumAddressLen && info.Payload[0] == EthereumAddressSpace
+	default:
 		return false
 	}
-
-	for i := 0; i < 4; i++ {
-		if check[i] != addressEnd[payLoadHashLength+i] {
-			return false
-		}
-	}
-	return true
 }
 
 func (dec *AddressDecoderV2) GetNtwk() string {
@@ -0,0 +1,194 @@
+package filecoin_addrdec
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/blocktree/go-owcrypt"
+)
+
+const (
+	ID_Protocol        = byte(0x00)
+	Actor_Protocol     = byte(0x02)
+	Delegated_Protocol = byte(0x04)
+
+	MaxIDAddressDigits   = 20 // MaxIDAddressDigits is the longest decimal representation of a uint64 actor id.
+	MaxSubaddressLen     = 54 // MaxSubaddressLen is the maximum length of a delegated address's sub-address.
+	EthereumAddressLen   = 20 // EthereumAddressLen is the length of an f410 sub-address.
+	EthereumAddressSpace = 10 // EthereumAddressSpace is the EAM actor id used as the f4 namespace of Ethereum addresses.
+)
+
+//AddressErrorReason 地址校验失败的原因
+type AddressErrorReason string
+
+const (
+	ErrReasonEmpty               AddressErrorReason = "empty"                // 地址为空
+	ErrReasonWrongNetwork        AddressErrorReason = "wrong_network"        // 网络前缀不匹配
+	ErrReasonUnsupportedProtocol AddressErrorReason = "unsupported_protocol" // 不支持的协议类型
+	ErrReasonInvalidLength       AddressErrorReason = "invalid_length"       // 长度错误
+	ErrReasonInvalidEncoding     AddressErrorReason = "invalid_encoding"     // 编码错误
+	ErrReasonInvalidChecksum     AddressErrorReason = "invalid_checksum"     // 校验和错误
+)
+
+//AddressError 地址校验错误，Reason 指明具体的失败原因
+type AddressError struct {
+	Address string
+	Reason  AddressErrorReason
+	Detail  string
+}
+
+func (e *AddressError) Error() string {
+	return fmt.Sprintf("invalid address %q [%s]: %s", e.Address, e.Reason, e.Detail)
+}
+
+func newAddressError(addr string, reason AddressErrorReason, format string, a ...interface{}) *AddressError {
+	return &AddressError{Address: addr, Reason: reason, Detail: fmt.Sprintf(format, a...)}
+}
+
+//AddressInfo 解析后的地址信息
+type AddressInfo struct {
+	Network  string // MainnetPrefix 或 TestnetPrefix
+	Protocol byte
+	Payload  []byte // ID地址为leb128编码的id，delegated地址为leb128(namespace)+sub-address
+}
+
+//IsTestNet 是否测试网地址
+func (info *AddressInfo) IsTestNet() bool {
+	return info.Network == TestnetPrefix
+}
+
+//ValidateAddress 校验地址，成功返回协议、网络与payload，失败返回 *AddressError
+func ValidateAddress(addr string, isTestNet bool) (*AddressInfo, error) {
+	if len(addr) == 0 {
+		return nil, newAddressError(addr, ErrReasonEmpty, "address is empty")
+	}
+	if len(addr) < 3 {
+		return nil, newAddressError(addr, ErrReasonInvalidLength, "address is too short")
+	}
+
+	expect := MainnetPrefix
+	if isTestNet {
+		expect = TestnetPrefix
+	}
+
+	network := addr[:1]
+	if network != MainnetPrefix && network != TestnetPrefix {
+		return nil, newAddressError(addr, ErrReasonWrongNetwork, "unknown network prefix %q", network)
+	}
+	if network != expect {
+		if network == MainnetPrefix {
+			return nil, newAddressError(addr, ErrReasonWrongNetwork, "mainnet address submitted on testnet")
+		}
+		return nil, newAddressError(addr, ErrReasonWrongNetwork, "testnet address submitted on mainnet")
+	}
+
+	if addr[1] < '0' || addr[1] > '9' {
+		return nil, newAddressError(addr, ErrReasonUnsupportedProtocol, "protocol %q is not a number", addr[1])
+	}
+	protocol := addr[1] - '0'
+	raw := addr[2:]
+
+	info := &AddressInfo{Network: network, Protocol: protocol}
+
+	switch protocol {
+	case ID_Protocol:
+		if len(raw) > MaxIDAddressDigits {
+			return nil, newAddressError(addr, ErrReasonInvalidLength, "id address has %d digits, max %d", len(raw), MaxIDAddressDigits)
+		}
+		id, err := strconv.ParseUint(raw, 10, 64)
+		if err != nil {
+			return nil, newAddressError(addr, ErrReasonInvalidEncoding, "id is not a valid uint64")
+		}
+		//与 go-address 一致，actor id 不超过 int64 的最大值
+		if id > math.MaxInt64 {
+			return nil, newAddressError(addr, ErrReasonInvalidEncoding, "id %d exceeds max int64", id)
+		}
+		info.Payload = putUvarint(id)
+		return info, nil
+	case Secp256k1_Protocol, Actor_Protocol:
+		payload, err := decodeChecked(addr, protocol, nil, raw, PayloadHashLength)
+		if err != nil {
+			return nil, err
+		}
+		info.Payload = payload
+		return info, nil
+	case Bls_Protocol:
+		payload, err := decodeChecked(addr, protocol, nil, raw, BlsPublicKeyBytes)
+		if err != nil {
+			return nil, err
+		}
+		info.Payload = payload
+		return info, nil
+	case Delegated_Protocol:
+		sep := strings.IndexByte(raw, 'f')
+		if sep <= 0 {
+			return nil, newAddressError(addr, ErrReasonInvalidEncoding, "delegated address is missing namespace separator")
+		}
+		namespace, err := strconv.ParseUint(raw[:sep], 10, 64)
+		if err != nil || len(raw[:sep]) > MaxIDAddressDigits || namespace > math.MaxInt64 {
+			return nil, newAddressError(addr, ErrReasonInvalidEncoding, "delegated namespace is not a valid actor id")
+		}
+		ns := putUvarint(namespace)
+		sub, err := decodeChecked(addr, protocol, ns, raw[sep+1:], -1)
+		if err != nil {
+			return nil, err
+		}
+		if len(sub) > MaxSubaddressLen {
+			return nil, newAddressError(addr, ErrReasonInvalidLength, "sub-address has %d bytes, max %d", len(sub), MaxSubaddressLen)
+		}
+		if namespace == EthereumAddressSpace && len(sub) != EthereumAddressLen {
+			return nil, newAddressError(addr, ErrReasonInvalidLength, "f410 sub-address has %d bytes, expect %d", len(sub), EthereumAddressLen)
+		}
+		info.Payload = append(ns, sub...)
+		return info, nil
+	default:
+		return nil, newAddressError(addr, ErrReasonUnsupportedProtocol, "protocol %d is not supported", protocol)
+	}
+}
+
+//ValidateAddress 按解析器的网络校验地址
+func (dec *AddressDecoderV2) ValidateAddress(addr string) (*AddressInfo, error) {
+	return ValidateAddress(addr, dec.IsTestNet)
+}
+
+//decodeChecked 解码base32部分并校验checksum，payloadLen<0 表示不限制长度
+func decodeChecked(addr string, protocol byte, prefix []byte, raw string, payloadLen int) ([]byte, error) {
+	if payloadLen >= 0 && len(raw) != addressEncoding.WithPadding(-1).EncodedLen(payloadLen+ChecksumHashLength) {
+		return nil, newAddressError(addr, ErrReasonInvalidLength, "protocol %d address must be %d characters", protocol,
+			2+addressEncoding.WithPadding(-1).EncodedLen(payloadLen+ChecksumHashLength))
+	}
+
+	decoded, err := addressEncoding.WithPadding(-1).DecodeString(raw)
+	if err != nil {
+		return nil, newAddressError(addr, ErrReasonInvalidEncoding, "invalid base32 payload")
+	}
+	//末尾的填充位必须为0，否则同一地址会有多种写法
+	if addressEncoding.WithPadding(-1).EncodeToString(decoded) != raw {
+		return nil, newAddressError(addr, ErrReasonInvalidEncoding, "non-canonical base32 payload")
+	}
+	if len(decoded) <= ChecksumHashLength {
+		return nil, newAddressError(addr, ErrReasonInvalidLength, "payload is too short")
+	}
+
+	payload := decoded[:len(decoded)-ChecksumHashLength]
+	cksm := decoded[len(decoded)-ChecksumHashLength:]
+
+	ingest := append([]byte{protocol}, prefix...)
+	ingest = append(ingest, payload...)
+	expect := owcrypt.Hash(ingest, ChecksumHashLength, owcrypt.HASH_ALG_BLAKE2B)
+	for i := 0; i < ChecksumHashLength; i++ {
+		if expect[i] != cksm[i] {
+			return nil, newAddressError(addr, ErrReasonInvalidChecksum, "checksum mismatch")
+		}
+	}
+	return payload, nil
+}
+
+func putUvarint(v uint64) []byte {
+	buf := make([]byte, binary.MaxVarintLen64)
+	n := binary.PutUvarint(buf, v)
+	return buf[:n]
+}
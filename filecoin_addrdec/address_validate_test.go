@@ -0,0 +1,83 @@
+package filecoin_addrdec
+
+import (
+	"encoding/hex"
+	"testing"
+)
+
+func TestValidateAddress(t *testing.T) {
+	tests := []struct {
+		addr      string
+		isTestNet bool
+		protocol  byte
+		payload   string
+		reason    AddressErrorReason
+	}{
+		{addr: "t01024", isTestNet: true, protocol: ID_Protocol, payload: "8008"},
+		{addr: "f09223372036854775807", isTestNet: false, protocol: ID_Protocol, payload: "ffffffffffffffff7f"},
+		{addr: "t1jre6fotop7nbydzs65jprh7bu66cz6gkoi4k5ky", isTestNet: true, protocol: Secp256k1_Protocol},
+		{addr: "f1jre6fotop7nbydzs65jprh7bu66cz6gkoi4k5ky", isTestNet: false, protocol: Secp256k1_Protocol},
+		{addr: "f23epq3sisucgbtkozssy7wqsnl4ixhp76jz7uwoi", isTestNet: false, protocol: Actor_Protocol},
+		{addr: "t3aaaqeayeaudaocajbifqydiob4ibceqtcqkrmfyydenbwha5dypsaijcemsckjrhfausukzmfuxc7xayzmkq", isTestNet: true, protocol: Bls_Protocol,
+			payload: "000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f202122232425262728292a2b2c2d2e2f"},
+		{addr: "f410fkkld55ioe7qg24wvt7fu6pbknb56ht7pt4zamxa", isTestNet: false, protocol: Delegated_Protocol,
+			payload: "0a52963ef50e27e06d72d59fcb4f3c2a687be3cfef"},
+
+		{addr: "", isTestNet: true, reason: ErrReasonEmpty},
+		{addr: "f1jre6fotop7nbydzs65jprh7bu66cz6gkoi4k5ky", isTestNet: true, reason: ErrReasonWrongNetwork},
+		{addr: "t1jre6fotop7nbydzs65jprh7bu66cz6gkoi4k5ky", isTestNet: false, reason: ErrReasonWrongNetwork},
+		{addr: "x1jre6fotop7nbydzs65jprh7bu66cz6gkoi4k5ky", isTestNet: false, reason: ErrReasonWrongNetwork},
+		{addr: "t7jre6fotop7nbydzs65jprh7bu66cz6gkoi4k5ky", isTestNet: true, reason: ErrReasonUnsupportedProtocol},
+		{addr: "t1jre6fotop7nbydzs65jprh7bu66cz6gkoi4k5k", isTestNet: true, reason: ErrReasonInvalidLength},
+		{addr: "t1jre6fotop7nbydzs65jprh7bu66cz6gkoi4k5la", isTestNet: true, reason: ErrReasonInvalidChecksum},
+		{addr: "t1jre6fotop7nbydzs65jprh7bu66cz6gkoi4k5kz", isTestNet: true, reason: ErrReasonInvalidEncoding},
+		{addr: "t1jre6fotop7nbydzs65jprh7bu66cz6gkoi4k5k1", isTestNet: true, reason: ErrReasonInvalidEncoding},
+		{addr: "t0abc", isTestNet: true, reason: ErrReasonInvalidEncoding},
+		{addr: "f09223372036854775808", isTestNet: false, reason: ErrReasonInvalidEncoding},
+		{addr: "f018446744073709551615", isTestNet: false, reason: ErrReasonInvalidEncoding},
+		{addr: "f410fkkld55ioe7qg24wvt7fu6pbknb56ht7pt4zamya", isTestNet: false, reason: ErrReasonInvalidChecksum},
+	}
+
+	for _, tt := range tests {
+		info, err := ValidateAddress(tt.addr, tt.isTestNet)
+		if len(tt.reason) > 0 {
+			addrErr, ok := err.(*AddressError)
+			if !ok {
+				t.Errorf("%s: expect AddressError, got %v", tt.addr, err)
+				continue
+			}
+			if addrErr.Reason != tt.reason {
+				t.Errorf("%s: expect reason %s, got %s (%v)", tt.addr, tt.reason, addrErr.Reason, addrErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.addr, err)
+			continue
+		}
+		if info.Protocol != tt.protocol {
+			t.Errorf("%s: expect protocol %d, got %d", tt.addr, tt.protocol, info.Protocol)
+		}
+		if info.IsTestNet() != tt.isTestNet {
+			t.Errorf("%s: wrong network %s", tt.addr, info.Network)
+		}
+		if len(tt.payload) > 0 && hex.EncodeToString(info.Payload) != tt.payload {
+			t.Errorf("%s: expect payload %s, got %x", tt.addr, tt.payload, info.Payload)
+		}
+	}
+}
+
+func TestAddressDecoder_AddressVerifyProtocols(t *testing.T) {
+	dec := NewAddressDecoderV2(true)
+	if !dec.AddressVerify("t1jre6fotop7nbydzs65jprh7bu66cz6gkoi4k5ky") {
+		t.Errorf("secp256k1 address should pass")
+	}
+	if dec.AddressVerify("t01024") {
+		t.Errorf("id address should not pass wallet address verify")
+	}
+
+	dec = NewAddressDecoderV2(false)
+	if !dec.AddressVerify("f410fkkld55ioe7qg24wvt7fu6pbknb56ht7pt4zamxa") {
+		t.Errorf("f410 address should pass")
+	}
+}
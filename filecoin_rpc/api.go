@@ -15,7 +15,6 @@
 package filecoin_rpc
 
 import (
-	"errors"
 	"fmt"
 	"github.com/blocktree/openwallet/v2/log"
 	"github.com/imroc/req"
@@ -27,6 +26,16 @@ type Client struct {
 	Debug   bool
 }
 
+//Error 节点返回的JSON-RPC错误，说明节点已处理并拒绝了请求
+type Error struct {
+	Code    int64
+	Message string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("[%d]%s", e.Code, e.Message)
+}
+
 func (c *Client) CallWithToken(accessToken, method string, params []interface{}) (*gjson.Result, error) {
 	authHeader := req.Header{
 		"Accept":       "application/json",
@@ -113,10 +122,10 @@ func isError(result *gjson.Result) error {
 		return nil
 	}
 
-	errInfo := fmt.Sprintf("[%d]%s",
-		result.Get("error.code").Int(),
-		result.Get("error.message").String())
-	err = errors.New(errInfo)
+	err = &Error{
+		Code:    result.Get("error.code").Int(),
+		Message: result.Get("error.message").String(),
+	}
 
 	return err
 }
@@ -8,7 +8,7 @@ require (
 	github.com/blocktree/go-owcrypt v1.1.9
 	github.com/blocktree/openwallet/v2 v2.0.4
 	github.com/ethereum/go-ethereum v1.9.9
-	github.com/filecoin-project/go-address v0.0.4
+	github.com/filecoin-project/go-address v1.1.0
 	github.com/filecoin-project/go-crypto v0.0.0-20191218222705-effae4ea9f03
 	github.com/filecoin-project/go-state-types v0.0.0-20200928172055-2df22083d8ab
 	github.com/filecoin-project/specs-actors v0.9.13
@@ -19,6 +19,6 @@ require (
 	github.com/prometheus/common v0.6.0
 	github.com/shopspring/decimal v0.0.0-20200105231215-408a2507e114
 	github.com/tidwall/gjson v1.3.5
-	github.com/whyrusleeping/cbor-gen v0.0.0-20200812213548-958ddffe352c
+	github.com/whyrusleeping/cbor-gen v0.0.0-20210303213153-67a261a1d291
 	golang.org/x/xerrors v0.0.0-20200804184101-5ec99f83aff1
 )